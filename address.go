@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const (
+	TCP_SCHEME = "tcp"
+	HTTP_SCHEME = "http"
+	HTTPS_SCHEME = "https"
+	UNIX_SCHEME = "unix"
+)
+
+// DialContextFunc opens connections to nodes in place of net.Dialer, for
+// example through a SOCKS proxy or to an in-memory listener in tests.
+type DialContextFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// nodeAddress says how to reach a node listed in the cluster config.
+// Entries are either a host with an optional port, such as 10.23.20.2,
+// node1:7991 or [::1]:7991, which use the cache's protocol and the port
+// passed to NewCache if none is given, or URLs with a tcp, http, https or
+// unix scheme, e.g. https://node3:8443 or unix:///var/run/ghostdb.sock for
+// a sidecar.
+type nodeAddress struct {
+	scheme  string
+	network string
+	address string
+}
+
+func parseNodeAddress(node string, defaultScheme string, defaultPort string) (nodeAddress, error) {
+	if !strings.Contains(node, "://") {
+		host, port, err := net.SplitHostPort(node)
+		if err != nil {
+			host, port = strings.Trim(node, "[]"), defaultPort
+		}
+		return nodeAddress{
+			scheme:  defaultScheme,
+			network: "tcp",
+			address: net.JoinHostPort(host, port),
+		}, nil
+	}
+
+	u, err := url.Parse(node)
+	if err != nil {
+		return nodeAddress{}, err
+	}
+	switch u.Scheme {
+	case UNIX_SCHEME:
+		path := u.Host + u.Path
+		if path == "" {
+			return nodeAddress{}, fmt.Errorf("node address %q has no socket path", node)
+		}
+		return nodeAddress{scheme: UNIX_SCHEME, network: "unix", address: path}, nil
+	case TCP_SCHEME, HTTP_SCHEME, HTTPS_SCHEME:
+		if u.Hostname() == "" {
+			return nodeAddress{}, fmt.Errorf("node address %q has no host", node)
+		}
+		port := u.Port()
+		if port == "" {
+			port = defaultPort
+		}
+		return nodeAddress{
+			scheme:  u.Scheme,
+			network: "tcp",
+			address: net.JoinHostPort(u.Hostname(), port),
+		}, nil
+	default:
+		return nodeAddress{}, fmt.Errorf("node address %q has unsupported scheme %q", node, u.Scheme)
+	}
+}
+
+// nodeID returns the identity a cluster config entry is hashed onto the
+// ring under unless the entry sets one with id=: its host, so that changing
+// a node's port or scheme does not move its keys. Unix socket entries are
+// identified by their path.
+func nodeID(node string) string {
+	if strings.Contains(node, "://") {
+		u, err := url.Parse(node)
+		if err != nil {
+			return node
+		}
+		if u.Scheme == UNIX_SCHEME {
+			return u.Host + u.Path
+		}
+		return u.Hostname()
+	}
+	host, _, err := net.SplitHostPort(node)
+	if err != nil {
+		return strings.Trim(node, "[]")
+	}
+	return host
+}
+
+// url returns the URL of an endpoint on the node. Requests to a Unix
+// socket still need a host in their URL, but it is never dialed.
+func (this nodeAddress) url(endpoint string) string {
+	scheme := HTTP_SCHEME
+	if this.scheme == HTTPS_SCHEME {
+		scheme = HTTPS_SCHEME
+	}
+	host := this.address
+	if this.network == "unix" {
+		host = "localhost"
+	}
+	return scheme + "://" + host + endpoint
+}
+
+func (this *Cache) nodeAddress(node string) (nodeAddress, error) {
+	return parseNodeAddress(node, strings.TrimSuffix(this.protocol, "://"), this.port)
+}
+
+// SetDialContext sets the function used to open connections to nodes over
+// HTTP. Pass nil to use net.Dialer.
+func (this *Cache) SetDialContext(dial DialContextFunc) {
+	this.dialContext = dial
+	if transport, ok := this.transport.(*httpTransport); ok {
+		transport.resetPools()
+	}
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestParseNodeAddress(t *testing.T) {
+	cases := []struct {
+		node    string
+		network string
+		address string
+		url     string
+	}{
+		{"10.23.20.2", "tcp", "10.23.20.2:7991", "http://10.23.20.2:7991/get"},
+		{"node1:7992", "tcp", "node1:7992", "http://node1:7992/get"},
+		{"[::1]:7992", "tcp", "[::1]:7992", "http://[::1]:7992/get"},
+		{"::1", "tcp", "[::1]:7991", "http://[::1]:7991/get"},
+		{"https://[::1]:8443", "tcp", "[::1]:8443", "https://[::1]:8443/get"},
+		{"http://node1", "tcp", "node1:7991", "http://node1:7991/get"},
+		{"https://node3:8443", "tcp", "node3:8443", "https://node3:8443/get"},
+		{"tcp://node2:9000", "tcp", "node2:9000", "http://node2:9000/get"},
+		{"unix:///var/run/ghostdb.sock", "unix", "/var/run/ghostdb.sock", "http://localhost/get"},
+	}
+	for _, c := range cases {
+		addr, err := parseNodeAddress(c.node, HTTP_SCHEME, "7991")
+		AssertEqual(t, err, nil, "")
+		AssertEqual(t, addr.network, c.network, "")
+		AssertEqual(t, addr.address, c.address, "")
+		AssertEqual(t, addr.url("/get"), c.url, "")
+	}
+
+	for _, node := range []string{"ftp://node1", "unix://", "https://:8443"} {
+		_, err := parseNodeAddress(node, HTTP_SCHEME, "7991")
+		AssertEqual(t, err != nil, true, "")
+	}
+}
+
+func TestNodeID(t *testing.T) {
+	cases := map[string]string{
+		"10.23.20.2":                   "10.23.20.2",
+		"10.23.20.2:7991":              "10.23.20.2",
+		"[::1]:7991":                   "::1",
+		"::1":                          "::1",
+		"https://node3:8443":           "node3",
+		"tcp://node3":                  "node3",
+		"unix:///var/run/ghostdb.sock": "/var/run/ghostdb.sock",
+	}
+	for node, id := range cases {
+		AssertEqual(t, nodeID(node), id, "")
+	}
+}
+
+func TestNodePortFromConfig(t *testing.T) {
+	fake := &fakeServer{objects: make(map[string]*fakeObject)}
+	server := httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(server.Close)
+
+	// The port passed to NewCache is only a default for entries without
+	// one, and an explicit ID leaves the address untouched.
+	cache := NewCache(writeClusterConfig(t, server.Listener.Addr().String()+" id=node-1"), true, "1")
+	_, err := cache.Put("key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	value, _, err := cache.GetString("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "value", "")
+}
+
+func TestUnixSocketNode(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "ghostdb.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip("unix sockets unavailable: " + err.Error())
+	}
+	fake := &fakeServer{objects: make(map[string]*fakeObject)}
+	server := &http.Server{Handler: http.HandlerFunc(fake.handle)}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	cache := NewCache(writeClusterConfig(t, "unix://"+socket), true, "7991")
+	_, err = cache.Put("key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	value, _, err := cache.GetString("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "value", "")
+}
+
+// pipeListener serves connections made in memory with net.Pipe.
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+
+	mu     sync.Mutex
+	dialed []string
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (this *pipeListener) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	this.mu.Lock()
+	this.dialed = append(this.dialed, address)
+	this.mu.Unlock()
+
+	client, server := net.Pipe()
+	select {
+	case this.conns <- server:
+		return client, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-this.done:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (this *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-this.conns:
+		return conn, nil
+	case <-this.done:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (this *pipeListener) Close() error {
+	this.once.Do(func() { close(this.done) })
+	return nil
+}
+
+func (this *pipeListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "memory", Net: "memory"}
+}
+
+func TestCustomDialContext(t *testing.T) {
+	listener := newPipeListener()
+	fake := &fakeServer{objects: make(map[string]*fakeObject)}
+	server := &http.Server{Handler: http.HandlerFunc(fake.handle)}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	cache := NewCache(writeClusterConfig(t, "memory-node"), true, "7991")
+	cache.SetDialContext(listener.DialContext)
+
+	_, err := cache.Put("key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	value, _, err := cache.GetString("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "value", "")
+
+	listener.mu.Lock()
+	defer listener.mu.Unlock()
+	AssertEqual(t, listener.dialed[0], "memory-node:7991", "")
+}
+
+func TestTCPTransportCustomDialContext(t *testing.T) {
+	standIn := newTCPStandIn(t)
+	transport := NewTCPTransport("7991")
+	defer transport.Close()
+	var dialed string
+	transport.SetDialContext(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = address
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, standIn.listener.Addr().String())
+	})
+	request := NewCacheRequest(CacheRequestParams{TTL: -1})
+
+	_, err := transport.RoundTrip(context.Background(), "tcp://node-a:9000", "ping", request)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, dialed, "node-a:9000", "")
+
+	_, err = transport.RoundTrip(context.Background(), "https://node-b", "ping", request)
+	AssertEqual(t, err != nil, true, "")
+}
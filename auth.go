@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_API_KEY_HEADER = "X-API-Key"
+	HMAC_KEY_ID_HEADER = "X-GhostDB-Key-Id"
+	HMAC_TIMESTAMP_HEADER = "X-GhostDB-Timestamp"
+	HMAC_NONCE_HEADER = "X-GhostDB-Nonce"
+	HMAC_SIGNATURE_HEADER = "X-GhostDB-Signature"
+	BEARER_REFRESH_MARGIN = 30 * time.Second
+)
+
+const (
+	EMPTY_BEARER_TOKEN_ERROR = "Token refresh returned an empty token"
+	AUTHENTICATOR_TRANSPORT_ERROR = "Authenticators are only supported over HTTP"
+)
+
+// Authenticator adds credentials to every HTTP request sent to a node,
+// including the pings that revive dead nodes. body is the request body as
+// it is sent on the wire.
+type Authenticator interface {
+	Authenticate(request *http.Request, body []byte) error
+}
+
+// SetAuthenticator sets the credentials sent with every request over HTTP.
+// Pass nil to send requests without credentials. TCPTransport has no way
+// to carry credentials, so setting one while it is in use is an error.
+func (this *Cache) SetAuthenticator(authenticator Authenticator) error {
+	if _, ok := this.transport.(*TCPTransport); ok && authenticator != nil {
+		return errors.New(AUTHENTICATOR_TRANSPORT_ERROR)
+	}
+	this.authenticator = authenticator
+	return nil
+}
+
+// tokenInvalidator is implemented by authenticators holding credentials
+// that a node can reject before they expire. On a 401 the credentials are
+// dropped and the request is retried once with fresh ones.
+type tokenInvalidator interface {
+	Invalidate()
+}
+
+// APIKeyAuthenticator sends a static API key in a header, X-API-Key
+// unless Header is set.
+type APIKeyAuthenticator struct {
+	Header string
+	Key    string
+}
+
+func (this APIKeyAuthenticator) Authenticate(request *http.Request, body []byte) error {
+	header := this.Header
+	if header == "" {
+		header = DEFAULT_API_KEY_HEADER
+	}
+	request.Header.Set(header, this.Key)
+	return nil
+}
+
+// TokenRefresher fetches a new bearer token and the time it expires. A
+// zero expiry means the token is used until a node rejects it.
+type TokenRefresher func() (token string, expires time.Time, err error)
+
+// BearerTokenAuthenticator sends an Authorization bearer token, calling
+// its refresher for a new token shortly before the current one expires or
+// when a node rejects it.
+type BearerTokenAuthenticator struct {
+	refresh TokenRefresher
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func NewBearerTokenAuthenticator(refresh TokenRefresher) *BearerTokenAuthenticator {
+	return &BearerTokenAuthenticator{refresh: refresh}
+}
+
+func (this *BearerTokenAuthenticator) Authenticate(request *http.Request, body []byte) error {
+	token, err := this.currentToken()
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (this *BearerTokenAuthenticator) Invalidate() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.token = ""
+}
+
+func (this *BearerTokenAuthenticator) currentToken() (string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.token != "" && (this.expires.IsZero() || time.Now().Add(BEARER_REFRESH_MARGIN).Before(this.expires)) {
+		return this.token, nil
+	}
+	token, expires, err := this.refresh()
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", errors.New(EMPTY_BEARER_TOKEN_ERROR)
+	}
+	this.token, this.expires = token, expires
+	return token, nil
+}
+
+// HMACAuthenticator signs each request with a shared secret. The signature
+// is the hex HMAC-SHA256 of the method, path, unix timestamp, nonce and hex
+// SHA-256 of the body, each followed by a newline, so a proxy can reject
+// altered, stale or replayed requests.
+type HMACAuthenticator struct {
+	KeyID  string
+	Secret []byte
+}
+
+func (this HMACAuthenticator) Authenticate(request *http.Request, body []byte) error {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonceHex := hex.EncodeToString(nonce[:])
+
+	request.Header.Set(HMAC_KEY_ID_HEADER, this.KeyID)
+	request.Header.Set(HMAC_TIMESTAMP_HEADER, timestamp)
+	request.Header.Set(HMAC_NONCE_HEADER, nonceHex)
+	request.Header.Set(HMAC_SIGNATURE_HEADER, this.signature(request.Method, request.URL.Path, timestamp, nonceHex, body))
+	return nil
+}
+
+func (this HMACAuthenticator) signature(method, path, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, this.Secret)
+	for _, field := range []string{method, path, timestamp, nonce, hex.EncodeToString(bodyHash[:])} {
+		mac.Write([]byte(field))
+		mac.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	cache, fake := newTestCache(t)
+	fake.authenticate = func(r *http.Request, body []byte) bool {
+		return r.Header.Get("X-Ghost-Key") == "secret"
+	}
+	cache.SetAuthenticator(APIKeyAuthenticator{Header: "X-Ghost-Key", Key: "secret"})
+
+	_, err := cache.Put("key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+
+	request, _ := http.NewRequest(http.MethodPost, "http://node/get", nil)
+	APIKeyAuthenticator{Key: "secret"}.Authenticate(request, nil)
+	AssertEqual(t, request.Header.Get(DEFAULT_API_KEY_HEADER), "secret", "")
+}
+
+func TestBearerTokenAuthenticatorRefreshes(t *testing.T) {
+	cache, fake := newTestCache(t)
+	var mu sync.Mutex
+	valid, refreshes := "token-1", 0
+	fake.authenticate = func(r *http.Request, body []byte) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return r.Header.Get("Authorization") == "Bearer "+valid
+	}
+	cache.SetAuthenticator(NewBearerTokenAuthenticator(func() (string, time.Time, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		refreshes++
+		return "token-" + strconv.Itoa(refreshes), time.Now().Add(time.Hour), nil
+	}))
+
+	_, err := cache.Put("key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	_, err = cache.Get("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, refreshes, 1, "")
+
+	// The node revokes the token before it expires.
+	mu.Lock()
+	valid = "token-2"
+	mu.Unlock()
+	_, err = cache.Get("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, refreshes, 2, "")
+	AssertEqual(t, len(cache.getDeadServers()), 0, "")
+}
+
+func TestBearerTokenAuthenticatorRefreshesBeforeExpiry(t *testing.T) {
+	refreshes := 0
+	auth := NewBearerTokenAuthenticator(func() (string, time.Time, error) {
+		refreshes++
+		return "token", time.Now().Add(BEARER_REFRESH_MARGIN / 2), nil
+	})
+	request, _ := http.NewRequest(http.MethodPost, "http://node/get", nil)
+	AssertEqual(t, auth.Authenticate(request, nil), nil, "")
+	AssertEqual(t, auth.Authenticate(request, nil), nil, "")
+	AssertEqual(t, refreshes, 2, "")
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	cache, fake := newTestCache(t)
+	auth := HMACAuthenticator{KeyID: "client-1", Secret: []byte("shared secret")}
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	fake.authenticate = func(r *http.Request, body []byte) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		nonce := r.Header.Get(HMAC_NONCE_HEADER)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(HMAC_TIMESTAMP_HEADER), 10, 64)
+		if seen[nonce] || time.Since(time.Unix(timestamp, 0)) > time.Minute {
+			return false
+		}
+		seen[nonce] = true
+		want := auth.signature(r.Method, r.URL.Path, r.Header.Get(HMAC_TIMESTAMP_HEADER), nonce, body)
+		return r.Header.Get(HMAC_KEY_ID_HEADER) == "client-1" && r.Header.Get(HMAC_SIGNATURE_HEADER) == want
+	}
+	cache.SetAuthenticator(auth)
+
+	_, err := cache.Put("key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	value, _, err := cache.GetString("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "value", "")
+	AssertEqual(t, len(seen), 2, "")
+
+	cache.SetAuthenticator(HMACAuthenticator{KeyID: "client-1", Secret: []byte("wrong")})
+	_, err = cache.Get("key")
+	var authErr *AuthError
+	AssertEqual(t, errors.As(err, &authErr), true, "")
+	AssertEqual(t, authErr.StatusCode, http.StatusUnauthorized, "")
+	AssertEqual(t, len(cache.getDeadServers()), 0, "")
+}
+
+func TestSetAuthenticatorRejectsTCPTransport(t *testing.T) {
+	cache, _ := newTestCache(t)
+	transport := NewTCPTransport("0")
+	defer transport.Close()
+	cache.SetTransport(transport)
+
+	AssertEqual(t, cache.SetAuthenticator(APIKeyAuthenticator{Key: "secret"}) != nil, true, "")
+	AssertEqual(t, cache.SetAuthenticator(nil), nil, "")
+}
+
+func TestSetTransportRejectsTCPWithAuthenticator(t *testing.T) {
+	cache, _ := newTestCache(t)
+	AssertEqual(t, cache.SetAuthenticator(APIKeyAuthenticator{Key: "secret"}), nil, "")
+	transport := NewTCPTransport("0")
+	defer transport.Close()
+
+	AssertEqual(t, cache.SetTransport(transport) != nil, true, "")
+	_, isHTTP := cache.transport.(*httpTransport)
+	AssertEqual(t, isHTTP, true, "")
+
+	AssertEqual(t, cache.SetAuthenticator(nil), nil, "")
+	AssertEqual(t, cache.SetTransport(transport), nil, "")
+}
+
+func TestRevivalPingsAreAuthenticated(t *testing.T) {
+	cache, fake := newTestCache(t)
+	fake.authenticate = func(r *http.Request, body []byte) bool {
+		return r.Header.Get(DEFAULT_API_KEY_HEADER) == "secret"
+	}
+	node := cache.ring.GetPoint("key")
+	cache.markDead(node)
+
+	attemptRevive(cache)
+	AssertEqual(t, len(cache.getDeadServers()), 1, "")
+
+	cache.SetAuthenticator(APIKeyAuthenticator{Key: "secret"})
+	attemptRevive(cache)
+	AssertEqual(t, len(cache.getDeadServers()), 0, "")
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+const GZIP_CONTENT_ENCODING = "gzip"
+
+const (
+	NEGATIVE_BODY_COMPRESSION_THRESHOLD_ERROR = "Body compression threshold must not be negative"
+)
+
+// EnableBodyCompression gzips HTTP request bodies of at least threshold
+// bytes and asks nodes for gzipped responses, which pays off for large
+// batch and metrics payloads. Unlike SetCompression this compresses the
+// whole request on the wire rather than the stored value.
+func (this *Cache) EnableBodyCompression(threshold int) error {
+	if threshold < 0 {
+		return errors.New(NEGATIVE_BODY_COMPRESSION_THRESHOLD_ERROR)
+	}
+	this.bodyCompressionThreshold = threshold
+	this.bodyCompression = true
+	return nil
+}
+
+func (this *Cache) DisableBodyCompression() {
+	this.bodyCompression = false
+}
+
+// compressRequestBody gzips body if body compression applies to it,
+// returning the body to send and its Content-Encoding.
+func (this *Cache) compressRequestBody(body []byte) ([]byte, string, error) {
+	if !this.bodyCompression || len(body) < this.bodyCompressionThreshold {
+		return body, "", nil
+	}
+	compressed, err := GzipCompressor{}.Compress(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return compressed, GZIP_CONTENT_ENCODING, nil
+}
+
+// readResponseBody reads a response body, decompressing it if the node
+// gzipped it. net/http only does this itself when it set Accept-Encoding.
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get("Content-Encoding") == GZIP_CONTENT_ENCODING {
+		return GzipCompressor{}.Decompress(body)
+	}
+	return body, nil
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBodyCompressionAboveThreshold(t *testing.T) {
+	cache, fake := newTestCache(t)
+	AssertEqual(t, cache.EnableBodyCompression(1024), nil, "")
+
+	_, err := cache.Put("small", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, atomic.LoadInt32(&fake.gzipRequests), int32(0), "")
+
+	large := strings.Repeat("ghostdb ", 1024)
+	_, err = cache.Put("large", large, NoExpiry)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, atomic.LoadInt32(&fake.gzipRequests), int32(1), "")
+
+	value, _, err := cache.GetString("large")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, large, "")
+}
+
+func TestBodyCompressionAcceptsGzippedResponses(t *testing.T) {
+	cache, fake := newTestCache(t)
+	fake.gzipResponses = true
+	AssertEqual(t, cache.EnableBodyCompression(0), nil, "")
+
+	_, err := cache.Put("key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, atomic.LoadInt32(&fake.gzipRequests), int32(1), "")
+
+	value, _, err := cache.GetString("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "value", "")
+}
+
+func TestBodyCompressionDisabled(t *testing.T) {
+	cache, fake := newTestCache(t)
+	AssertEqual(t, cache.EnableBodyCompression(0), nil, "")
+	cache.DisableBodyCompression()
+
+	_, err := cache.Put("key", strings.Repeat("ghostdb ", 1024), NoExpiry)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, atomic.LoadInt32(&fake.gzipRequests), int32(0), "")
+}
+
+func TestEnableBodyCompressionRejectsNegativeThreshold(t *testing.T) {
+	cache, _ := newTestCache(t)
+	AssertEqual(t, cache.EnableBodyCompression(-1) != nil, true, "")
+}
@@ -31,16 +31,29 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"io/ioutil"
-	"net/http"
+	"math"
+	"math/rand"
+	"sync"
 	"time"
 )
 
 const (
 	NO_MORE_SERVERS_ERROR = "All nodes marked as dead: Failed to establish a connection to any servers: (Check your fleet status)"
+	DECR_OVERFLOW_ERROR = "Decr delta must be greater than math.MinInt64"
+)
+
+const (
+	CACHE_MISS = "CACHE_MISS"
+	CAS_CONFLICT = "CAS_CONFLICT"
+	NOT_NUMERIC = "NOT_NUMERIC"
+)
+
+const (
+	UPDATE_MAX_RETRIES = 10
+	UPDATE_BASE_BACKOFF = 5 * time.Millisecond
+	UPDATE_MAX_BACKOFF = 500 * time.Millisecond
 )
 
 const (
@@ -50,11 +63,41 @@ const (
 
 type Cache struct {
 	deadServers    map[string]bool
+	deadMu         sync.Mutex
 	configFilepath string
 	ring           *Ring
 	protocol       string
 	port           string
 	reviveInterval int32
+	defaultTTL     time.Duration
+	ttlJitter      float64
+	loads          *flightGroup
+	staleWindow    time.Duration
+	xfetchBeta     float64
+	nearCache      *nearCache
+	absentKeys     *nearCache
+	tagVersionCache *nearCache
+	negativeTTL    time.Duration
+	negativeMode   int
+	codec          Codec
+	compressor     Compressor
+	compressThreshold int
+	keyring        *Keyring
+	signingKey     []byte
+	chunkSize      int
+	wireEncoding   WireEncoding
+	jsonOnlyNodes  map[string]bool
+	wireMu         sync.Mutex
+	transport      Transport
+	bodyCompression bool
+	bodyCompressionThreshold int
+	authenticator  Authenticator
+	defaultHeaders Metadata
+	requestLogger  RequestLogger
+	headerRedactor HeaderRedactor
+	dialContext    DialContextFunc
+	poolMu         sync.Mutex
+	poolConfig     PoolConfig
 }
 
 func NewCache(configFilepath string, http bool, port string) *Cache {
@@ -72,13 +115,76 @@ func NewCache(configFilepath string, http bool, port string) *Cache {
 		protocol: protocol,
 		port: port,
 		reviveInterval: 30,
+		defaultTTL: NoExpiry,
+		loads: newFlightGroup(),
+		jsonOnlyNodes: make(map[string]bool),
+		chunkSize: DEFAULT_CHUNK_SIZE,
+		poolConfig: DefaultPoolConfig(),
 	}
+	cache.transport = newHTTPTransport(cache)
 
 	go startServerRevival(cache)
 	return cache
 }
 
 func (this *Cache) Get(key string) (CacheResponse, error) {
+	return this.GetContext(context.Background(), key)
+}
+
+// GetContext is like Get but sends the metadata carried by ctx.
+func (this *Cache) GetContext(ctx context.Context, key string) (CacheResponse, error) {
+	response, _, err := this.get(ctx, key, true)
+	return response, err
+}
+
+// GetRemote reads key from the cluster, bypassing the near-cache.
+func (this *Cache) GetRemote(key string) (CacheResponse, error) {
+	response, _, err := this.get(context.Background(), key, false)
+	return response, err
+}
+
+// GetInto decodes the value stored at key into dst, which must be a
+// pointer. found is false if the key is not cached or known absent.
+func (this *Cache) GetInto(key string, dst interface{}) (bool, error) {
+	response, env, err := this.get(context.Background(), key, true)
+	if err != nil {
+		return false, err
+	}
+	if IsNotCached(response) || IsKnownAbsent(response) {
+		return false, nil
+	}
+	return true, decodeInto(response.Gobj.Value, env, dst)
+}
+
+// get reads key, returning the stored value along with the envelope it
+// was wrapped in, if any.
+func (this *Cache) get(ctx context.Context, key string, useLocal bool) (CacheResponse, *valueEnvelope, error) {
+	if useLocal && this.absentKeys != nil {
+		if response, _, ok := this.absentKeys.Get(key); ok {
+			return response, nil, nil
+		}
+	}
+	if useLocal && this.nearCache != nil {
+		if response, env, ok := this.nearCache.Get(key); ok {
+			return response, env, nil
+		}
+	}
+
+	response, err := this.getRemote(ctx, key)
+	if err != nil {
+		return response, nil, err
+	}
+	response, env, err := this.openResponse(ctx, key, response)
+	if err != nil {
+		return response, nil, err
+	}
+	if this.nearCache != nil && response.Message != CACHE_MISS {
+		this.nearCache.Set(key, response, env)
+	}
+	return response, env, nil
+}
+
+func (this *Cache) getRemote(ctx context.Context, key string) (CacheResponse, error) {
 	node := this.ring.GetPoint(key)
 	if node == nil {
 		return CacheResponse{}, errors.New(NO_MORE_SERVERS_ERROR)
@@ -90,10 +196,13 @@ func (this *Cache) Get(key string) (CacheResponse, error) {
 		TTL: -1,
 	}
 
-	response, err := this.makeServiceRequest("get", node, serviceRequestParams)
+	response, err := this.makeServiceRequest(ctx, "get", node, serviceRequestParams)
 	if err != nil {
+		if ctx.Err() != nil || !isNodeFailure(err) {
+			return CacheResponse{}, err
+		}
 		this.markDead(node)
-		return this.Get(key)
+		return this.getRemote(ctx, key)
 	}
 	return response, nil
 }
@@ -110,15 +219,33 @@ func (this *Cache) NodeSize(ip string) (CacheResponse, error) {
 		TTL: -1,
 	}
 
-	response, err := this.makeServiceRequest("getNodeSize", node, serviceRequestParams)
+	response, err := this.makeServiceRequest(context.Background(), "getNodeSize", node, serviceRequestParams)
 	if err != nil {
+		if !isNodeFailure(err) {
+			return CacheResponse{}, err
+		}
 		this.markDead(node)
 		return this.NodeSize(ip)
 	}
 	return response, nil
 }
 
-func (this *Cache) Add(key string, value interface{}, ttl int) (CacheResponse, error) {
+func (this *Cache) Add(key string, value interface{}, ttl time.Duration) (CacheResponse, error) {
+	return this.AddContext(context.Background(), key, value, ttl)
+}
+
+// AddContext is like Add but sends the metadata carried by ctx.
+func (this *Cache) AddContext(ctx context.Context, key string, value interface{}, ttl time.Duration) (CacheResponse, error) {
+	seconds, err := this.resolveTTL(ttl)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+
+	sealed, err := this.sealValue(key, value)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+
 	node := this.ring.GetPoint(key)
 	if node == nil {
 		return CacheResponse{}, errors.New(NO_MORE_SERVERS_ERROR)
@@ -126,19 +253,38 @@ func (this *Cache) Add(key string, value interface{}, ttl int) (CacheResponse, e
 
 	serviceRequestParams := CacheRequestParams{
 		Key: key,
-		Value: value,
-		TTL: ttl,
+		Value: sealed,
+		TTL: seconds,
 	}
 
-	response, err := this.makeServiceRequest("add", node, serviceRequestParams)
+	response, err := this.makeServiceRequest(ctx, "add", node, serviceRequestParams)
 	if err != nil {
+		if ctx.Err() != nil || !isNodeFailure(err) {
+			return CacheResponse{}, err
+		}
 		this.markDead(node)
-		return this.Add(key, value, ttl)
+		return this.AddContext(ctx, key, value, ttl)
 	}
+	this.invalidateLocal(key)
 	return response, nil
 }
 
-func (this *Cache) Put(key string, value interface{}, ttl int) (CacheResponse, error) {
+func (this *Cache) Put(key string, value interface{}, ttl time.Duration) (CacheResponse, error) {
+	return this.PutContext(context.Background(), key, value, ttl)
+}
+
+// PutContext is like Put but sends the metadata carried by ctx.
+func (this *Cache) PutContext(ctx context.Context, key string, value interface{}, ttl time.Duration) (CacheResponse, error) {
+	seconds, err := this.resolveTTL(ttl)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+
+	sealed, err := this.sealValue(key, value)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+
 	node := this.ring.GetPoint(key)
 	if node == nil {
 		return CacheResponse{}, errors.New(NO_MORE_SERVERS_ERROR)
@@ -146,19 +292,322 @@ func (this *Cache) Put(key string, value interface{}, ttl int) (CacheResponse, e
 
 	serviceRequestParams := CacheRequestParams{
 		Key: key,
-		Value: value,
-		TTL: ttl,
+		Value: sealed,
+		TTL: seconds,
 	}
 
-	response, err := this.makeServiceRequest("put", node, serviceRequestParams)
+	response, err := this.makeServiceRequest(ctx, "put", node, serviceRequestParams)
 	if err != nil {
+		if ctx.Err() != nil || !isNodeFailure(err) {
+			return CacheResponse{}, err
+		}
 		this.markDead(node)
-		return this.Put(key, value, ttl)
+		return this.PutContext(ctx, key, value, ttl)
 	}
+	this.invalidateLocal(key)
 	return response, nil
 }
 
+// Gets fetches a key along with its version token. The token is returned
+// in response.Gobj.Version and can be passed to CompareAndSwap.
+func (this *Cache) Gets(key string) (CacheResponse, error) {
+	return this.GetsContext(context.Background(), key)
+}
+
+// GetsContext is like Gets but sends the metadata carried by ctx.
+func (this *Cache) GetsContext(ctx context.Context, key string) (CacheResponse, error) {
+	node := this.ring.GetPoint(key)
+	if node == nil {
+		return CacheResponse{}, errors.New(NO_MORE_SERVERS_ERROR)
+	}
+
+	serviceRequestParams := CacheRequestParams{
+		Key: key,
+		Value: "",
+		TTL: -1,
+	}
+
+	response, err := this.makeServiceRequest(ctx, "gets", node, serviceRequestParams)
+	if err != nil {
+		if ctx.Err() != nil || !isNodeFailure(err) {
+			return CacheResponse{}, err
+		}
+		this.markDead(node)
+		return this.GetsContext(ctx, key)
+	}
+	response, _, err = this.openResponse(ctx, key, response)
+	return response, err
+}
+
+// CompareAndSwap stores value only if the key is still at the given
+// version. A *CasConflictError is returned if another writer got there first.
+func (this *Cache) CompareAndSwap(key string, value interface{}, version uint64, ttl time.Duration) (CacheResponse, error) {
+	return this.CompareAndSwapContext(context.Background(), key, value, version, ttl)
+}
+
+// CompareAndSwapContext is like CompareAndSwap but sends the metadata carried by ctx.
+func (this *Cache) CompareAndSwapContext(ctx context.Context, key string, value interface{}, version uint64, ttl time.Duration) (CacheResponse, error) {
+	seconds, err := this.resolveTTL(ttl)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+
+	sealed, err := this.sealValue(key, value)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+
+	node := this.ring.GetPoint(key)
+	if node == nil {
+		return CacheResponse{}, errors.New(NO_MORE_SERVERS_ERROR)
+	}
+
+	serviceRequestParams := CacheRequestParams{
+		Key: key,
+		Value: sealed,
+		TTL: seconds,
+		Version: version,
+	}
+
+	response, err := this.makeServiceRequest(ctx, "cas", node, serviceRequestParams)
+	if err != nil {
+		if ctx.Err() != nil || !isNodeFailure(err) {
+			return CacheResponse{}, err
+		}
+		this.markDead(node)
+		return this.CompareAndSwapContext(ctx, key, value, version, ttl)
+	}
+	this.invalidateLocal(key)
+	if response.Message == CAS_CONFLICT {
+		return response, &CasConflictError{Key: key, Version: version}
+	}
+	return response, nil
+}
+
+// Update applies fn to the current value of key and writes the result back
+// with CompareAndSwap, retrying with exponential backoff when a concurrent
+// writer wins the race. fn is called with nil if the key does not exist.
+func (this *Cache) Update(key string, fn func(old interface{}) interface{}) (CacheResponse, error) {
+	var lastErr error
+	backoff := UPDATE_BASE_BACKOFF
+
+	for attempt := 0; attempt < UPDATE_MAX_RETRIES; attempt++ {
+		current, err := this.Gets(key)
+		if err != nil {
+			return CacheResponse{}, err
+		}
+
+		if current.Message == CACHE_MISS {
+			response, err := this.Add(key, fn(nil), DefaultTTL)
+			if err != nil {
+				return response, err
+			}
+			if response.Status == 1 {
+				return response, nil
+			}
+			lastErr = &CasConflictError{Key: key}
+		} else if IsKnownAbsent(current) {
+			// The key holds an absent sentinel, so there is no old value
+			// but the sentinel must be swapped out rather than added over.
+			response, err := this.CompareAndSwap(key, fn(nil), current.Gobj.Version, DefaultTTL)
+			if err == nil {
+				return response, nil
+			}
+			if _, ok := err.(*CasConflictError); !ok {
+				return response, err
+			}
+			lastErr = err
+		} else {
+			newValue := fn(current.Gobj.Value)
+			response, err := this.CompareAndSwap(key, newValue, current.Gobj.Version, keepTTL(current.Gobj.TTL))
+			if err == nil {
+				return response, nil
+			}
+			if _, ok := err.(*CasConflictError); !ok {
+				return response, err
+			}
+			lastErr = err
+		}
+
+		time.Sleep(backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)))
+		backoff *= 2
+		if backoff > UPDATE_MAX_BACKOFF {
+			backoff = UPDATE_MAX_BACKOFF
+		}
+	}
+	return CacheResponse{}, lastErr
+}
+
+// Incr atomically adds delta to the integer stored at key on the key's node
+// and returns the new value. Missing keys are initialised to delta.
+func (this *Cache) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	return this.IncrContext(context.Background(), key, delta, ttl)
+}
+
+// IncrContext is like Incr but sends the metadata carried by ctx.
+func (this *Cache) IncrContext(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	seconds, err := this.resolveTTL(ttl)
+	if err != nil {
+		return 0, err
+	}
+
+	node := this.ring.GetPoint(key)
+	if node == nil {
+		return 0, errors.New(NO_MORE_SERVERS_ERROR)
+	}
+
+	serviceRequestParams := CacheRequestParams{
+		Key: key,
+		Value: "",
+		TTL: seconds,
+		Delta: delta,
+	}
+
+	response, err := this.makeServiceRequest(ctx, "incr", node, serviceRequestParams)
+	if err != nil {
+		if ctx.Err() != nil || !isNodeFailure(err) {
+			return 0, err
+		}
+		this.markDead(node)
+		return this.IncrContext(ctx, key, delta, ttl)
+	}
+	this.invalidateLocal(key)
+	if response.Message == NOT_NUMERIC {
+		return 0, &NotNumericError{Key: key}
+	}
+
+	value, ok := toInt64(response.Gobj.Value)
+	if !ok {
+		return 0, &NotNumericError{Key: key}
+	}
+	return value, nil
+}
+
+// Decr atomically subtracts delta from the integer stored at key and
+// returns the new value.
+func (this *Cache) Decr(key string, delta int64, ttl time.Duration) (int64, error) {
+	return this.DecrContext(context.Background(), key, delta, ttl)
+}
+
+// DecrContext is like Decr but sends the metadata carried by ctx.
+func (this *Cache) DecrContext(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	if delta == math.MinInt64 {
+		// -delta would overflow back to math.MinInt64.
+		return 0, errors.New(DECR_OVERFLOW_ERROR)
+	}
+	return this.IncrContext(ctx, key, -delta, ttl)
+}
+
+// Touch resets the TTL of key without rewriting its value.
+func (this *Cache) Touch(key string, ttl time.Duration) (CacheResponse, error) {
+	return this.TouchContext(context.Background(), key, ttl)
+}
+
+// TouchContext is like Touch but sends the metadata carried by ctx.
+func (this *Cache) TouchContext(ctx context.Context, key string, ttl time.Duration) (CacheResponse, error) {
+	seconds, err := this.resolveTTL(ttl)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+
+	node := this.ring.GetPoint(key)
+	if node == nil {
+		return CacheResponse{}, errors.New(NO_MORE_SERVERS_ERROR)
+	}
+
+	serviceRequestParams := CacheRequestParams{
+		Key: key,
+		Value: "",
+		TTL: seconds,
+	}
+
+	response, err := this.makeServiceRequest(ctx, "touch", node, serviceRequestParams)
+	if err != nil {
+		if ctx.Err() != nil || !isNodeFailure(err) {
+			return CacheResponse{}, err
+		}
+		this.markDead(node)
+		return this.TouchContext(ctx, key, ttl)
+	}
+	this.invalidateLocal(key)
+	return response, nil
+}
+
+// GetAndTouch fetches key and resets its TTL in a single round trip.
+func (this *Cache) GetAndTouch(key string, ttl time.Duration) (CacheResponse, error) {
+	return this.GetAndTouchContext(context.Background(), key, ttl)
+}
+
+// GetAndTouchContext is like GetAndTouch but sends the metadata carried by ctx.
+func (this *Cache) GetAndTouchContext(ctx context.Context, key string, ttl time.Duration) (CacheResponse, error) {
+	seconds, err := this.resolveTTL(ttl)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+
+	node := this.ring.GetPoint(key)
+	if node == nil {
+		return CacheResponse{}, errors.New(NO_MORE_SERVERS_ERROR)
+	}
+
+	serviceRequestParams := CacheRequestParams{
+		Key: key,
+		Value: "",
+		TTL: seconds,
+	}
+
+	response, err := this.makeServiceRequest(ctx, "getAndTouch", node, serviceRequestParams)
+	if err != nil {
+		if ctx.Err() != nil || !isNodeFailure(err) {
+			return CacheResponse{}, err
+		}
+		this.markDead(node)
+		return this.GetAndTouchContext(ctx, key, ttl)
+	}
+	this.invalidateLocal(key)
+	response, _, err = this.openResponse(ctx, key, response)
+	return response, err
+}
+
+// TTL returns the remaining lifetime of key, or NoExpiry if it never
+// expires. found is false if the key is not in the cache.
+func (this *Cache) TTL(key string) (time.Duration, bool, error) {
+	return this.TTLContext(context.Background(), key)
+}
+
+// TTLContext is like TTL but sends the metadata carried by ctx.
+func (this *Cache) TTLContext(ctx context.Context, key string) (time.Duration, bool, error) {
+	node := this.ring.GetPoint(key)
+	if node == nil {
+		return 0, false, errors.New(NO_MORE_SERVERS_ERROR)
+	}
+
+	serviceRequestParams := CacheRequestParams{
+		Key: key,
+		Value: "",
+		TTL: -1,
+	}
+
+	response, err := this.makeServiceRequest(ctx, "ttl", node, serviceRequestParams)
+	if err != nil {
+		if ctx.Err() != nil || !isNodeFailure(err) {
+			return 0, false, err
+		}
+		this.markDead(node)
+		return this.TTLContext(ctx, key)
+	}
+	if response.Message == CACHE_MISS {
+		return 0, false, nil
+	}
+	return ttlFromSeconds(response.Gobj.TTL), true, nil
+}
+
 func (this *Cache) Delete(key string) (CacheResponse, error) {
+	return this.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is like Delete but sends the metadata carried by ctx.
+func (this *Cache) DeleteContext(ctx context.Context, key string) (CacheResponse, error) {
 	node := this.ring.GetPoint(key)
 	if node == nil {
 		return CacheResponse{}, errors.New(NO_MORE_SERVERS_ERROR)
@@ -170,11 +619,15 @@ func (this *Cache) Delete(key string) (CacheResponse, error) {
 		TTL: -1,
 	}
 
-	response, err := this.makeServiceRequest("delete", node, serviceRequestParams)
+	response, err := this.makeServiceRequest(ctx, "delete", node, serviceRequestParams)
 	if err != nil {
+		if ctx.Err() != nil || !isNodeFailure(err) {
+			return CacheResponse{}, err
+		}
 		this.markDead(node)
-		return this.Delete(key)
+		return this.DeleteContext(ctx, key)
 	}
+	this.invalidateLocal(key)
 	return response, nil
 }
 
@@ -187,12 +640,18 @@ func (this *Cache) Flush() (bool, error) {
 			TTL: -1,
 		}
 		pairNode := &Pair{index: vp.index, value: vp}
-		_, err := this.makeServiceRequest("flush", pairNode, serviceRequestParams)
+		_, err := this.makeServiceRequest(context.Background(), "flush", pairNode, serviceRequestParams)
 		if err != nil {
+			if !isNodeFailure(err) {
+				return false, err
+			}
 			this.markDead(pairNode)
 			return this.Flush()
 		}
 	}
+	if this.nearCache != nil {
+		this.nearCache.Clear()
+	}
 	return true, nil
 }
 
@@ -211,7 +670,13 @@ func (this *Cache) recGetSysMetrics(metrics []*Metric, visitedNodes []string) ([
 				TTL: -1,
 			}
 			pairNode := &Pair{index: vp.index, value: vp}
-			resp, err := this.makeServiceRequest("getSysMetrics", pairNode, serviceRequestParams)
+			resp, err := this.makeServiceRequest(context.Background(), "getSysMetrics", pairNode, serviceRequestParams)
+			if err != nil && !isNodeFailure(err) {
+				// The node is up but refused the request, so it has no
+				// metrics to report.
+				visitedNodes = append(visitedNodes, pairNode.value.ip)
+				continue
+			}
 			if err != nil {
 				this.markDead(pairNode)
 				return this.recGetSysMetrics(metrics, visitedNodes)
@@ -238,7 +703,11 @@ func (this *Cache) recGetAppMetrics(metrics []*Metric, visitedNodes []string) ([
 				TTL: -1,
 			}
 			pairNode := &Pair{index: vp.index, value: vp}
-			resp, err := this.makeServiceRequest("getAppMetrics", pairNode, serviceRequestParams)
+			resp, err := this.makeServiceRequest(context.Background(), "getAppMetrics", pairNode, serviceRequestParams)
+			if err != nil && !isNodeFailure(err) {
+				visitedNodes = append(visitedNodes, pairNode.value.ip)
+				continue
+			}
 			if err != nil {
 				this.markDead(pairNode)
 				return this.recGetAppMetrics(metrics, visitedNodes)
@@ -265,7 +734,11 @@ func (this *Cache) recPing(metrics []*Metric, visitedNodes []string) ([]*Metric)
 				TTL: -1,
 			}
 			pairNode := &Pair{index: vp.index, value: vp}
-			resp, err := this.makeServiceRequest("ping", pairNode, serviceRequestParams)
+			resp, err := this.makeServiceRequest(context.Background(), "ping", pairNode, serviceRequestParams)
+			if err != nil && !isNodeFailure(err) {
+				visitedNodes = append(visitedNodes, pairNode.value.ip)
+				continue
+			}
 			if err != nil {
 				this.markDead(pairNode)
 				return this.recPing(metrics, visitedNodes)
@@ -296,16 +769,14 @@ func attemptRevive(cache *Cache) {
 		TTL: -1,
 	}
 	requestObj := NewCacheRequest(serviceRequestParams)
-	requestBody, _ := json.Marshal(requestObj)
-	for server, _ := range cache.deadServers {
-		url := cache.protocol + server + ":" + cache.port + "/ping"
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(requestBody))
-		if err != nil {
-			continue
-		}
-		if resp.StatusCode == 200 {
+	for _, server := range cache.getDeadServers() {
+		ctx := cache.requestContext(context.Background())
+		_, err := cache.transport.RoundTrip(ctx, server, "ping", requestObj)
+		if err == nil {
+			cache.deadMu.Lock()
 			cache.ring.Add(server)
 			delete(cache.deadServers, server)
+			cache.deadMu.Unlock()
 		}
 	}
 }
@@ -319,42 +790,44 @@ func exists(slice []string, val string) bool {
 	return false
 }
 
-func (this *Cache) makeServiceRequest(requestType string, server *Pair, params CacheRequestParams) (CacheResponse, error) {
-	requestObj := NewCacheRequest(params)
-	requestBody, err := json.Marshal(requestObj)
-
-	url := this.protocol + server.value.ip + ":" + this.port + getRequestType(requestType)
-	
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return CacheResponse{}, err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-
-	if err != nil {
-		return CacheResponse{}, err
+func (this *Cache) makeServiceRequest(ctx context.Context, requestType string, server *Pair, params CacheRequestParams) (CacheResponse, error) {
+	ctx = this.requestContext(ctx)
+	if err := MetadataFromContext(ctx).validate(); err != nil {
+		return CacheResponse{}, &RequestError{Node: server.value.ip, Err: err}
 	}
-
-	var responseObj CacheResponse
-	err = json.Unmarshal(body, &responseObj) 
-	if err != nil {
-		return CacheResponse{}, err
-	}
-	return responseObj, nil
+	response, err := this.transport.RoundTrip(ctx, server.value.ip, requestType, NewCacheRequest(params))
+	this.logRequest(ctx, server.value.ip, requestType, err)
+	return response, err
 }
 
 func (this *Cache) markDead(server *Pair) {
+	this.deadMu.Lock()
+	defer this.deadMu.Unlock()
 	this.deadServers[server.value.ip] = true
 	this.ring.Delete(server.value.ip)
 }
 
+func (this *Cache) getDeadServers() []string {
+	this.deadMu.Lock()
+	defer this.deadMu.Unlock()
+	var servers []string
+	for server := range this.deadServers {
+		servers = append(servers, server)
+	}
+	return servers
+}
+
 func getRequestType(requestType string) string {
 	endpoints := map[string]string {
 		"ping": "/ping",
 		"put": "/put",
 		"get": "/get",
+		"gets": "/gets",
+		"cas": "/cas",
+		"incr": "/incr",
+		"touch": "/touch",
+		"getAndTouch": "/getAndTouch",
+		"ttl": "/ttl",
 		"add": "/add",
 		"delete": "/delete",
 		"flush": "/flush",
@@ -32,10 +32,12 @@ package main
 
 type CacheRequest struct {
 	Gobj GhostObject `json:"Gobj"`
+	Delta int64 `json:"Delta"`
 }
 
 func NewCacheRequest(params CacheRequestParams) CacheRequest {
 	return CacheRequest{
 		Gobj: NewGhostObject(params),
+		Delta: params.Delta,
 	}
 }
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	cache, _ := newTestCache(t)
+	_, err := cache.Put("key", "v1", NoExpiry)
+	AssertEqual(t, err, nil, "")
+
+	current, err := cache.Gets("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, current.Gobj.Version > 0, true, "")
+
+	_, err = cache.CompareAndSwap("key", "v2", current.Gobj.Version, NoExpiry)
+	AssertEqual(t, err, nil, "")
+	value, _, err := cache.GetString("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "v2", "")
+}
+
+func TestCompareAndSwapConflict(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.Put("key", "v1", NoExpiry)
+	stale, _ := cache.Gets("key")
+	cache.Put("key", "v2", NoExpiry)
+
+	_, err := cache.CompareAndSwap("key", "v3", stale.Gobj.Version, NoExpiry)
+	conflict, ok := err.(*CasConflictError)
+	AssertEqual(t, ok, true, "")
+	AssertEqual(t, conflict.Key, "key", "")
+	AssertEqual(t, conflict.Version, stale.Gobj.Version, "")
+
+	value, _, _ := cache.GetString("key")
+	AssertEqual(t, value, "v2", "")
+}
+
+func TestUpdateRetriesAfterConflict(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.Put("counter", float64(1), NoExpiry)
+
+	calls := 0
+	_, err := cache.Update("counter", func(old interface{}) interface{} {
+		calls++
+		if calls == 1 {
+			// Another writer gets in between the read and the swap.
+			cache.Put("counter", float64(10), NoExpiry)
+		}
+		return old.(float64) + 1
+	})
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, calls, 2, "")
+
+	value, _, _ := cache.GetFloat64("counter")
+	AssertEqual(t, value, float64(11), "")
+}
+
+func TestUpdateMissingKey(t *testing.T) {
+	cache, _ := newTestCache(t)
+	_, err := cache.Update("key", func(old interface{}) interface{} {
+		AssertEqual(t, old, nil, "")
+		return "created"
+	})
+	AssertEqual(t, err, nil, "")
+	value, _, _ := cache.GetString("key")
+	AssertEqual(t, value, "created", "")
+}
+
+func TestIncrDecr(t *testing.T) {
+	cache, _ := newTestCache(t)
+	value, err := cache.Incr("counter", 5, NoExpiry)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, int64(5), "")
+
+	value, err = cache.Decr("counter", 2, NoExpiry)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, int64(3), "")
+}
+
+func TestDecrRejectsMinInt64(t *testing.T) {
+	cache, _ := newTestCache(t)
+	_, err := cache.Decr("counter", math.MinInt64, NoExpiry)
+	AssertEqual(t, err != nil, true, "")
+	AssertEqual(t, len(cache.getDeadServers()), 0, "")
+}
+
+func TestRejectedRequestsDoNotMarkNodesDead(t *testing.T) {
+	cache, fake := newTestCache(t)
+	fake.status = http.StatusBadRequest
+
+	_, err := cache.Get("key")
+	var statusErr *StatusError
+	AssertEqual(t, errors.As(err, &statusErr), true, "")
+	AssertEqual(t, statusErr.StatusCode, http.StatusBadRequest, "")
+	AssertEqual(t, len(cache.getDeadServers()), 0, "")
+
+	fake.status = http.StatusServiceUnavailable
+	_, err = cache.Get("key")
+	AssertEqual(t, err.Error(), NO_MORE_SERVERS_ERROR, "")
+	AssertEqual(t, len(cache.getDeadServers()), 1, "")
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	RAW_CODEC_TYPE_ERROR = "Raw codec only supports []byte values"
+)
+
+// Codec converts values to and from the bytes stored in GhostDB.
+type Codec interface {
+	// Name identifies the codec in stored values so they can be decoded
+	// by any client that has it registered.
+	Name() string
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, value interface{}) error
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(JSONCodec{})
+	RegisterCodec(GobCodec{})
+	RegisterCodec(MsgpackCodec{})
+	RegisterCodec(RawCodec{})
+}
+
+// RegisterCodec makes a codec available for decoding values that were
+// written with it. The codecs shipped with the SDK are registered already.
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.Name()] = codec
+}
+
+func lookupCodec(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("no codec registered with name %q", name)
+	}
+	return codec, nil
+}
+
+// SetCodec encodes values written by this client with codec. Values that
+// were written with other registered codecs, or without one, remain
+// readable. Passing nil restores the default of sending values as is.
+func (this *Cache) SetCodec(codec Codec) {
+	this.codec = codec
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+func (JSONCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec) Unmarshal(data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+// GobCodec encodes values with encoding/gob. Gob can only decode into a
+// concrete type, so values written with it must be read with GetInto.
+type GobCodec struct{}
+
+func (GobCodec) Name() string {
+	return "gob"
+}
+
+func (GobCodec) Marshal(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, value interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (MsgpackCodec) Marshal(value interface{}) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, value interface{}) error {
+	return msgpack.Unmarshal(data, value)
+}
+
+// RawCodec stores []byte values verbatim.
+type RawCodec struct{}
+
+func (RawCodec) Name() string {
+	return "raw"
+}
+
+func (RawCodec) Marshal(value interface{}) ([]byte, error) {
+	data, ok := value.([]byte)
+	if !ok {
+		return nil, errors.New(RAW_CODEC_TYPE_ERROR)
+	}
+	return data, nil
+}
+
+func (RawCodec) Unmarshal(data []byte, value interface{}) error {
+	switch dst := value.(type) {
+	case *[]byte:
+		*dst = append([]byte(nil), data...)
+	case *interface{}:
+		*dst = append([]byte(nil), data...)
+	default:
+		return errors.New(RAW_CODEC_TYPE_ERROR)
+	}
+	return nil
+}
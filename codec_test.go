@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+type testCity struct {
+	Name       string
+	Population int64
+}
+
+func TestCodecsRoundTripThroughCache(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec{}, GobCodec{}, MsgpackCodec{}} {
+		cache, _ := newTestCache(t)
+		cache.SetCodec(codec)
+
+		_, err := cache.Put("Ireland", testCity{Name: "Dublin", Population: 1173179}, NoExpiry)
+		AssertEqual(t, err, nil, codec.Name())
+
+		var city testCity
+		found, err := cache.GetInto("Ireland", &city)
+		AssertEqual(t, err, nil, codec.Name())
+		AssertEqual(t, found, true, codec.Name())
+		AssertEqual(t, city, testCity{Name: "Dublin", Population: 1173179}, codec.Name())
+	}
+}
+
+func TestRawCodec(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.SetCodec(RawCodec{})
+
+	_, err := cache.Put("blob", []byte{0, 1, 2, 255}, NoExpiry)
+	AssertEqual(t, err, nil, "")
+
+	response, err := cache.Get("blob")
+	AssertEqual(t, err, nil, "")
+	AssertDeepEqual(t, response.Gobj.Value, []byte{0, 1, 2, 255}, "")
+
+	_, err = cache.Put("blob", "not bytes", NoExpiry)
+	AssertEqual(t, err != nil, true, "")
+}
+
+func TestGetIntoPlainValues(t *testing.T) {
+	cache, _ := newTestCache(t)
+
+	// Written without a codec, so the value travels as plain JSON.
+	cache.Put("Ireland", testCity{Name: "Dublin", Population: 1173179}, NoExpiry)
+
+	var city testCity
+	found, err := cache.GetInto("Ireland", &city)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, found, true, "")
+	AssertEqual(t, city.Population, int64(1173179), "")
+
+	found, err = cache.GetInto("France", &city)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, found, false, "")
+}
+
+func TestValuesWrittenWithOtherCodecsRemainReadable(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.SetCodec(MsgpackCodec{})
+	cache.Put("Ireland", "Dublin", NoExpiry)
+
+	cache.SetCodec(nil)
+	response, _ := cache.Get("Ireland")
+	AssertEqual(t, response.Gobj.Value, "Dublin", "")
+}
+
+func TestCorruptCodecValuesAreErrors(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+		env := &valueEnvelope{Codec: codec.Name(), plain: []byte{0xc1, '{'}}
+		_, err := env.value()
+		AssertEqual(t, err != nil, true, codec.Name())
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// MAX_DECOMPRESSED_SIZE bounds how large a compressed value may grow
+	// when it is read back, so a corrupt or hostile value cannot exhaust
+	// memory.
+	MAX_DECOMPRESSED_SIZE = 64 * 1024 * 1024
+)
+
+const (
+	NEGATIVE_COMPRESSION_THRESHOLD_ERROR = "Compression threshold must not be negative"
+	DECOMPRESSED_SIZE_ERROR              = "Decompressed value exceeds the maximum size"
+)
+
+// Compressor compresses encoded values before they are sent to GhostDB.
+type Compressor interface {
+	// Name identifies the compressor in stored values so they can be
+	// decompressed by any client that has it registered.
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{}
+)
+
+func init() {
+	RegisterCompressor(GzipCompressor{})
+	RegisterCompressor(ZstdCompressor{})
+	RegisterCompressor(SnappyCompressor{})
+}
+
+// RegisterCompressor makes a compressor available for reading values that
+// were written with it. The compressors shipped with the SDK are
+// registered already.
+func RegisterCompressor(compressor Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[compressor.Name()] = compressor
+}
+
+func lookupCompressor(name string) (Compressor, error) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	compressor, ok := compressors[name]
+	if !ok {
+		return nil, fmt.Errorf("no compressor registered with name %q", name)
+	}
+	return compressor, nil
+}
+
+// SetCompression compresses encoded values of at least threshold bytes
+// with compressor. Smaller values are stored uncompressed, and reads
+// handle either transparently. Values are encoded as JSON for compression
+// unless a codec has been set. Passing a nil compressor disables it.
+func (this *Cache) SetCompression(compressor Compressor, threshold int) error {
+	if threshold < 0 {
+		return errors.New(NEGATIVE_COMPRESSION_THRESHOLD_ERROR)
+	}
+	this.compressor = compressor
+	this.compressThreshold = threshold
+	return nil
+}
+
+type GzipCompressor struct{}
+
+func (GzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readDecompressed(reader)
+}
+
+// readDecompressed reads a decompressing reader to the end, failing once
+// it produces more than MAX_DECOMPRESSED_SIZE bytes.
+func readDecompressed(reader io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(reader, MAX_DECOMPRESSED_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MAX_DECOMPRESSED_SIZE {
+		return nil, errors.New(DECOMPRESSED_SIZE_ERROR)
+	}
+	return data, nil
+}
+
+// The zstd encoder is expensive to build but safe for concurrent use
+// through EncodeAll, so it is shared.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+type ZstdCompressor struct{}
+
+func (ZstdCompressor) Name() string {
+	return "zstd"
+}
+
+func (ZstdCompressor) Compress(data []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (ZstdCompressor) Decompress(data []byte) ([]byte, error) {
+	decoder, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	return readDecompressed(decoder)
+}
+
+type SnappyCompressor struct{}
+
+func (SnappyCompressor) Name() string {
+	return "snappy"
+}
+
+func (SnappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (SnappyCompressor) Decompress(data []byte) ([]byte, error) {
+	size, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if size > MAX_DECOMPRESSED_SIZE {
+		return nil, errors.New(DECOMPRESSED_SIZE_ERROR)
+	}
+	return snappy.Decode(nil, data)
+}
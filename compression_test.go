@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressorsRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("<div>GhostDB</div>", 100))
+	for _, compressor := range []Compressor{GzipCompressor{}, ZstdCompressor{}, SnappyCompressor{}} {
+		compressed, err := compressor.Compress(data)
+		AssertEqual(t, err, nil, compressor.Name())
+		AssertEqual(t, len(compressed) < len(data), true, compressor.Name())
+
+		decompressed, err := compressor.Decompress(compressed)
+		AssertEqual(t, err, nil, compressor.Name())
+		AssertEqual(t, string(decompressed), string(data), compressor.Name())
+	}
+}
+
+func TestDecompressionIsBounded(t *testing.T) {
+	data := make([]byte, MAX_DECOMPRESSED_SIZE+1)
+	for _, compressor := range []Compressor{GzipCompressor{}, ZstdCompressor{}, SnappyCompressor{}} {
+		compressed, err := compressor.Compress(data)
+		AssertEqual(t, err, nil, compressor.Name())
+
+		_, err = compressor.Decompress(compressed)
+		AssertEqual(t, err != nil, true, compressor.Name())
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.SetCompression(ZstdCompressor{}, 256)
+
+	small, err := cache.sealValue("small", "<p>tiny</p>")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, small, "<p>tiny</p>", "")
+
+	large, err := cache.sealValue("large", strings.Repeat("<p>fragment</p>", 100))
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, large.(*valueEnvelope).Compressor, "zstd", "")
+}
+
+func TestCompressedValuesReadTransparently(t *testing.T) {
+	cache, _ := newTestCache(t)
+	fragment := strings.Repeat("<p>fragment</p>", 100)
+
+	cache.Put("plain", fragment, NoExpiry)
+	cache.SetCompression(GzipCompressor{}, 64)
+	cache.Put("compressed", fragment, NoExpiry)
+	cache.Put("small", "<p>tiny</p>", NoExpiry)
+
+	for _, key := range []string{"plain", "compressed"} {
+		response, err := cache.Get(key)
+		AssertEqual(t, err, nil, key)
+		AssertEqual(t, response.Gobj.Value, fragment, key)
+	}
+	response, _ := cache.Get("small")
+	AssertEqual(t, response.Gobj.Value, "<p>tiny</p>", "")
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+const (
+	MISSING_ACTIVE_KEY_ERROR = "Keyring's active key ID has no key"
+)
+
+// Keyring holds the AES keys used to encrypt values, indexed by key ID.
+// Values are always encrypted with the active key, while any key in the
+// ring can decrypt, so keys can be rotated by adding a new active key and
+// retiring the old one once its values have expired.
+type Keyring struct {
+	active string
+	aeads  map[string]cipher.AEAD
+}
+
+// NewKeyring builds a keyring from AES-128, AES-192 or AES-256 keys.
+func NewKeyring(activeID string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[activeID]; !ok {
+		return nil, errors.New(MISSING_ACTIVE_KEY_ERROR)
+	}
+	keyring := &Keyring{
+		active: activeID,
+		aeads:  make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		keyring.aeads[id] = aead
+	}
+	return keyring, nil
+}
+
+// SetEncryption encrypts values written by this client with AES-GCM using
+// the keyring's active key. Passing nil disables encryption for writes,
+// but encrypted values can then no longer be read.
+func (this *Cache) SetEncryption(keyring *Keyring) {
+	this.keyring = keyring
+}
+
+// seal encrypts plaintext for key. The cache key is bound in as additional
+// data so a ciphertext copied to another key fails to decrypt.
+func (this *Keyring) seal(key string, plaintext []byte) (string, []byte, []byte, error) {
+	aead := this.aeads[this.active]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", nil, nil, err
+	}
+	return this.active, nonce, aead.Seal(nil, nonce, plaintext, []byte(key)), nil
+}
+
+func (this *Keyring) open(key string, keyID string, nonce []byte, ciphertext []byte) ([]byte, error) {
+	aead, ok := this.aeads[keyID]
+	if !ok {
+		return nil, &TamperError{Key: key, Reason: "unknown encryption key ID " + keyID}
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, &TamperError{Key: key, Reason: "malformed nonce"}
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, &TamperError{Key: key, Reason: "decryption failed"}
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func testKeyring(t *testing.T, active string) *Keyring {
+	keyring, err := NewKeyring(active, map[string][]byte{
+		"2020-01": bytes.Repeat([]byte{1}, 32),
+		"2020-02": bytes.Repeat([]byte{2}, 32),
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return keyring
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetEncryption(testKeyring(t, "2020-01"))
+
+	cache.Put("user:1:email", "jake@example.com", NoExpiry)
+
+	// Nothing readable reaches the server.
+	stored, _ := decodeEnvelope(roundTrip(t, fake.objects["user:1:email"].value))
+	AssertEqual(t, stored.KeyID, "2020-01", "")
+	AssertEqual(t, bytes.Contains(stored.Payload, []byte("jake")), false, "")
+
+	response, err := cache.Get("user:1:email")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, response.Gobj.Value, "jake@example.com", "")
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.SetEncryption(testKeyring(t, "2020-01"))
+	cache.Put("old", "written with the old key", NoExpiry)
+
+	cache.SetEncryption(testKeyring(t, "2020-02"))
+	cache.Put("new", "written with the new key", NoExpiry)
+
+	response, err := cache.Get("old")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, response.Gobj.Value, "written with the old key", "")
+	response, err = cache.Get("new")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, response.Gobj.Value, "written with the new key", "")
+}
+
+func TestEncryptionDetectsTampering(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetEncryption(testKeyring(t, "2020-01"))
+	cache.Put("user:1:email", "jake@example.com", NoExpiry)
+
+	// A ciphertext copied to another key must not decrypt there.
+	fake.objects["user:2:email"] = fake.objects["user:1:email"]
+	_, err := cache.Get("user:2:email")
+	_, isTamper := err.(*TamperError)
+	AssertEqual(t, isTamper, true, "")
+
+	// Nor may a flipped bit go unnoticed.
+	env, _ := decodeEnvelope(roundTrip(t, fake.objects["user:1:email"].value))
+	env.Payload[0] ^= 1
+	fake.objects["user:1:email"].value = roundTrip(t, env)
+	_, err = cache.Get("user:1:email")
+	_, isTamper = err.(*TamperError)
+	AssertEqual(t, isTamper, true, "")
+}
+
+func TestEncryptionRejectsUnencryptedValues(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetEncryption(testKeyring(t, "2020-01"))
+	cache.Put("user:1:email", "jake@example.com", NoExpiry)
+	sealed, _ := decodeEnvelope(roundTrip(t, fake.objects["user:1:email"].value))
+
+	stripped := *sealed
+	stripped.Codec = ""
+	stripped.Value = "mallory@example.com"
+	for name, value := range map[string]interface{}{
+		"plaintext": "mallory@example.com",
+		"absent":    map[string]interface{}{"_gdb": 1, "a": true},
+		"unsealed":  newValueEnvelope("mallory@example.com"),
+		"stripped":  &stripped,
+	} {
+		fake.objects["user:1:email"].value = roundTrip(t, value)
+		_, err := cache.Get("user:1:email")
+		_, isTamper := err.(*TamperError)
+		AssertEqual(t, isTamper, true, name)
+	}
+}
+
+func TestEncryptedKnownAbsent(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetEncryption(testKeyring(t, "2020-01"))
+	cache.EnableNegativeCaching(time.Minute, NEGATIVE_CACHE_REMOTE)
+	AssertEqual(t, cache.MarkAbsent("user:1:email"), nil, "")
+
+	stored, _ := decodeEnvelope(roundTrip(t, fake.objects["user:1:email"].value))
+	AssertEqual(t, stored.KeyID, "2020-01", "")
+
+	response, err := cache.Get("user:1:email")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, IsKnownAbsent(response), true, "")
+}
+
+func TestNewKeyringValidation(t *testing.T) {
+	_, err := NewKeyring("missing", map[string][]byte{"2020-01": bytes.Repeat([]byte{1}, 32)})
+	AssertEqual(t, err != nil, true, "")
+
+	_, err = NewKeyring("short", map[string][]byte{"short": []byte("too short")})
+	AssertEqual(t, err != nil, true, "")
+}
@@ -0,0 +1,297 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"time"
+)
+
+const (
+	ENVELOPE_VERSION = 1
+)
+
+// valueEnvelope wraps values that carry client side metadata alongside
+// them. It is stored as a plain JSON object so that the server does not
+// need to know anything about it.
+type valueEnvelope struct {
+	Marker     int         `json:"_gdb"`
+	Value      interface{} `json:"v"`
+	FreshUntil int64       `json:"fu,omitempty"`
+	Expiry     int64       `json:"x,omitempty"`
+	Delta      int64       `json:"d,omitempty"`
+	Absent     bool             `json:"a,omitempty"`
+	Tags       map[string]int64 `json:"t,omitempty"`
+	Codec      string           `json:"c,omitempty"`
+	Payload    []byte           `json:"p,omitempty"`
+	Compressor string           `json:"z,omitempty"`
+	KeyID      string           `json:"k,omitempty"`
+	Nonce      []byte           `json:"n,omitempty"`
+	Signature  []byte           `json:"s,omitempty"`
+
+	// plain holds the decrypted, decompressed payload once opened.
+	plain []byte
+}
+
+func newValueEnvelope(value interface{}) *valueEnvelope {
+	return &valueEnvelope{
+		Marker: ENVELOPE_VERSION,
+		Value:  value,
+	}
+}
+
+// decodeEnvelope recovers an envelope from a value returned by the server.
+// ok is false if the value was stored without one.
+func decodeEnvelope(value interface{}) (*valueEnvelope, bool) {
+	fields, isObject := value.(map[string]interface{})
+	if !isObject {
+		return nil, false
+	}
+	if _, isEnvelope := fields["_gdb"]; !isEnvelope {
+		return nil, false
+	}
+
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return nil, false
+	}
+	var env valueEnvelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return nil, false
+	}
+	return &env, true
+}
+
+// isStale reports whether the envelope is past its soft expiry.
+func (this *valueEnvelope) isStale(now time.Time) bool {
+	return this.FreshUntil != 0 && now.UnixNano() >= this.FreshUntil
+}
+
+// shouldRefreshEarly implements XFetch: each reader independently decides
+// to recompute ahead of expiry with a probability that rises as expiry
+// approaches and with how long the value took to compute. random must be
+// uniformly distributed in (0, 1].
+func (this *valueEnvelope) shouldRefreshEarly(now time.Time, beta float64, random float64) bool {
+	if this.Expiry == 0 || beta <= 0 {
+		return false
+	}
+	gap := -float64(this.Delta) * beta * math.Log(random)
+	return float64(now.UnixNano())+gap >= float64(this.Expiry)
+}
+
+// openResponse unwraps an enveloped value in a response so that callers
+// see the value they stored, and applies any envelope metadata that
+// changes the outcome of the read.
+func (this *Cache) openResponse(ctx context.Context, key string, response CacheResponse) (CacheResponse, *valueEnvelope, error) {
+	if response.Message == CACHE_MISS {
+		return response, nil, nil
+	}
+	env, ok := decodeEnvelope(response.Gobj.Value)
+	if this.signingKey != nil {
+		if !ok {
+			return CacheResponse{}, nil, &TamperError{Key: key, Reason: "value is not signed"}
+		}
+		if err := this.verifySignature(key, env); err != nil {
+			return CacheResponse{}, nil, err
+		}
+	}
+	if this.keyring != nil && (!ok || env.KeyID == "" || (!env.Absent && env.Codec == "")) {
+		return CacheResponse{}, nil, &TamperError{Key: key, Reason: "value is not encrypted"}
+	}
+	if !ok {
+		return response, nil, nil
+	}
+	if env.Absent {
+		if this.keyring != nil {
+			if _, err := this.openPayload(key, env); err != nil {
+				return CacheResponse{}, nil, err
+			}
+		}
+		// The sentinel's version is kept so that it can be replaced with
+		// CompareAndSwap.
+		absent := knownAbsentResponse(key)
+		absent.Gobj.Version = response.Gobj.Version
+		return absent, nil, nil
+	}
+	if len(env.Tags) > 0 {
+		valid, err := this.tagsValid(ctx, env.Tags)
+		if err != nil {
+			return CacheResponse{}, nil, err
+		}
+		if !valid {
+			return cacheMissResponse(key), nil, nil
+		}
+	}
+	if env.Codec != "" {
+		plain, err := this.openPayload(key, env)
+		if err != nil {
+			return CacheResponse{}, nil, err
+		}
+		env.plain = plain
+	}
+	value, err := env.value()
+	if err != nil {
+		return CacheResponse{}, nil, err
+	}
+	response.Gobj.Value = value
+	return response, env, nil
+}
+
+// sealValue prepares a value for writing to key, encoding, compressing
+// and encrypting it as configured.
+func (this *Cache) sealValue(key string, value interface{}) (interface{}, error) {
+	env, isEnvelope := value.(*valueEnvelope)
+	if isEnvelope && env.Absent {
+		if this.keyring == nil {
+			return this.sign(key, env), nil
+		}
+		// Absent markers carry no value, but are still encrypted so that
+		// reads can tell them from one injected by someone without the key.
+		sealed := *env
+		var err error
+		sealed.KeyID, sealed.Nonce, sealed.Payload, err = this.keyring.seal(key, nil)
+		if err != nil {
+			return nil, err
+		}
+		return this.sign(key, &sealed), nil
+	}
+	if this.codec == nil && this.compressor == nil && this.keyring == nil && this.signingKey == nil {
+		return value, nil
+	}
+	if !isEnvelope {
+		env = newValueEnvelope(value)
+	}
+
+	codec := this.codec
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	payload, err := codec.Marshal(env.Value)
+	if err != nil {
+		return nil, err
+	}
+	sealed := *env
+	sealed.Value = nil
+	sealed.Codec = codec.Name()
+
+	if this.compressor != nil && len(payload) >= this.compressThreshold {
+		payload, err = this.compressor.Compress(payload)
+		if err != nil {
+			return nil, err
+		}
+		sealed.Compressor = this.compressor.Name()
+	} else if !isEnvelope && this.codec == nil && this.keyring == nil && this.signingKey == nil {
+		// Only compression is configured and the value is below the
+		// threshold, so it is stored unchanged.
+		return value, nil
+	}
+	if this.keyring != nil {
+		sealed.KeyID, sealed.Nonce, payload, err = this.keyring.seal(key, payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sealed.Payload = payload
+	return this.sign(key, &sealed), nil
+}
+
+// openPayload returns the encoded value in env, undoing any encryption and
+// compression.
+func (this *Cache) openPayload(key string, env *valueEnvelope) ([]byte, error) {
+	payload := env.Payload
+	if env.KeyID != "" {
+		if this.keyring == nil {
+			return nil, &TamperError{Key: key, Reason: "value is encrypted but no keyring is configured"}
+		}
+		var err error
+		payload, err = this.keyring.open(key, env.KeyID, env.Nonce, payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if env.Compressor == "" {
+		return payload, nil
+	}
+	compressor, err := lookupCompressor(env.Compressor)
+	if err != nil {
+		return nil, err
+	}
+	return compressor.Decompress(payload)
+}
+
+// value returns the caller's value. Gob can only decode into a concrete
+// type, so gob values are returned as their encoded bytes for GetInto to
+// decode; any other codec that fails to decode the value is an error.
+func (this *valueEnvelope) value() (interface{}, error) {
+	if this.Codec == "" {
+		return this.Value, nil
+	}
+	codec, err := lookupCodec(this.Codec)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := codec.(GobCodec); ok {
+		return this.plain, nil
+	}
+	var value interface{}
+	if err := codec.Unmarshal(this.plain, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// decodeInto decodes a value read from the cache into dst. Values stored
+// with a codec are decoded by that codec, and anything else is assumed to
+// have been decoded from JSON by the transport.
+func decodeInto(value interface{}, env *valueEnvelope, dst interface{}) error {
+	if env != nil && env.Codec != "" {
+		codec, err := lookupCodec(env.Codec)
+		if err != nil {
+			return err
+		}
+		return codec.Unmarshal(env.plain, dst)
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, dst)
+}
+
+func cacheMissResponse(key string) CacheResponse {
+	return CacheResponse{
+		Gobj:    GhostObject{Key: key, TTL: -1},
+		Status:  0,
+		Message: CACHE_MISS,
+	}
+}
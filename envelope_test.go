@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// roundTrip mimics a value making its way to the server and back.
+func roundTrip(t *testing.T, value interface{}) interface{} {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err.Error())
+	}
+	return decoded
+}
+
+func TestDecodeEnvelope(t *testing.T) {
+	env := newValueEnvelope("Dublin")
+	env.FreshUntil = time.Now().Add(time.Minute).UnixNano()
+
+	decoded, ok := decodeEnvelope(roundTrip(t, env))
+	AssertEqual(t, ok, true, "")
+	AssertEqual(t, decoded.Value, "Dublin", "")
+	AssertEqual(t, decoded.isStale(time.Now()), false, "")
+	AssertEqual(t, decoded.isStale(time.Now().Add(2*time.Minute)), true, "")
+}
+
+func TestDecodeEnvelopeIgnoresPlainValues(t *testing.T) {
+	_, ok := decodeEnvelope(roundTrip(t, "Dublin"))
+	AssertEqual(t, ok, false, "")
+
+	_, ok = decodeEnvelope(roundTrip(t, map[string]string{"city": "Dublin"}))
+	AssertEqual(t, ok, false, "")
+}
+
+func TestShouldRefreshEarly(t *testing.T) {
+	now := time.Now()
+	env := newValueEnvelope("report")
+	env.Delta = int64(time.Second)
+
+	// Far from expiry nobody refreshes, even on an unlucky draw.
+	env.Expiry = now.Add(time.Hour).UnixNano()
+	AssertEqual(t, env.shouldRefreshEarly(now, 1, 0.001), false, "")
+
+	// Within a few compute times of expiry, low draws refresh and high
+	// draws keep serving the cached value.
+	env.Expiry = now.Add(2 * time.Second).UnixNano()
+	AssertEqual(t, env.shouldRefreshEarly(now, 1, 0.01), true, "")
+	AssertEqual(t, env.shouldRefreshEarly(now, 1, 0.99), false, "")
+
+	// A larger beta refreshes earlier.
+	AssertEqual(t, env.shouldRefreshEarly(now, 10, 0.5), true, "")
+
+	// Disabled when beta is 0 or no expiry was recorded.
+	AssertEqual(t, env.shouldRefreshEarly(now, 0, 0.01), false, "")
+	env.Expiry = 0
+	AssertEqual(t, env.shouldRefreshEarly(now, 1, 0.01), false, "")
+}
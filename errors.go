@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CasConflictError is returned by CompareAndSwap when the version supplied
+// by the caller no longer matches the version held by the server.
+type CasConflictError struct {
+	Key     string
+	Version uint64
+}
+
+func (this *CasConflictError) Error() string {
+	return fmt.Sprintf("CAS conflict on key %q: version %d is no longer current", this.Key, this.Version)
+}
+
+// NotNumericError is returned by Incr and Decr when the value already stored
+// under the key cannot be interpreted as an integer.
+type NotNumericError struct {
+	Key string
+}
+
+func (this *NotNumericError) Error() string {
+	return fmt.Sprintf("value stored at key %q is not numeric", this.Key)
+}
+
+// TamperError is returned when a stored value fails authentication, either
+// because it was modified, was written for a different key, or can't be
+// verified with the configured keys.
+type TamperError struct {
+	Key    string
+	Reason string
+}
+
+func (this *TamperError) Error() string {
+	return fmt.Sprintf("value stored at key %q failed verification: %s", this.Key, this.Reason)
+}
+
+// TypeError is returned by the typed getters when the stored value can't
+// be converted to the requested type.
+type TypeError struct {
+	Key   string
+	Want  string
+	Value interface{}
+}
+
+func (this *TypeError) Error() string {
+	return fmt.Sprintf("value stored at key %q is %T, not %s", this.Key, this.Value, this.Want)
+}
+
+// RequestError is returned when a request fails for a reason of its own,
+// such as the node rejecting it or it not being encodable, rather than
+// because the node is unreachable. The node is not marked dead and the
+// request is not retried elsewhere.
+type RequestError struct {
+	Node string
+	Err  error
+}
+
+func (this *RequestError) Error() string {
+	return fmt.Sprintf("request to node %s failed: %v", this.Node, this.Err)
+}
+
+func (this *RequestError) Unwrap() error {
+	return this.Err
+}
+
+// AuthError is returned when a node rejects a request's credentials with
+// 401 Unauthorized or 403 Forbidden.
+type AuthError struct {
+	Node       string
+	StatusCode int
+}
+
+func (this *AuthError) Error() string {
+	return fmt.Sprintf("node %s rejected the request's credentials with HTTP status %d", this.Node, this.StatusCode)
+}
+
+// isNodeFailure reports whether err means the node a request was sent to
+// could not serve it, so that it should be marked dead and the request
+// sent elsewhere. Dial, I/O and timeout errors and 5xx replies count;
+// errors the node returned for the request itself do not.
+func isNodeFailure(err error) bool {
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) {
+		return false
+	}
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
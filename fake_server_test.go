@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeServer is an in-memory stand-in for a GhostDB node that implements
+// enough of the HTTP API to exercise the SDK end to end.
+type fakeServer struct {
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+	version uint64
+	server  *httptest.Server
+
+	// acceptMsgpack lets clients talk to the node in MessagePack.
+	acceptMsgpack bool
+	// drop is the number of upcoming requests to answer by closing the
+	// connection.
+	drop int32
+	// gzipResponses compresses responses for clients that accept gzip.
+	gzipResponses bool
+	// gzipRequests counts requests that arrived gzipped.
+	gzipRequests int32
+	// reads counts get requests per key.
+	reads map[string]int
+	// authenticate, if set, rejects requests it returns false for with
+	// 401 Unauthorized.
+	authenticate func(r *http.Request, body []byte) bool
+	// status, if set, is the HTTP status every request is answered with.
+	status int
+}
+
+type fakeObject struct {
+	value   interface{}
+	version uint64
+	expires time.Time
+}
+
+// newTestCache returns a Cache whose only node is a fresh fakeServer.
+func newTestCache(t *testing.T) (*Cache, *fakeServer) {
+	return newTestCacheFor(t, &fakeServer{objects: make(map[string]*fakeObject)})
+}
+
+func newTestCacheFor(t testing.TB, fake *fakeServer) (*Cache, *fakeServer) {
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(fake.server.Close)
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(fake.server.URL, "http://"))
+
+	return NewCache(writeClusterConfig(t, host), true, port), fake
+}
+
+// writeClusterConfig writes a cluster config listing nodes and returns its
+// path.
+func writeClusterConfig(t testing.TB, nodes ...string) string {
+	config := filepath.Join(t.TempDir(), "cluster.conf")
+	if err := ioutil.WriteFile(config, []byte(strings.Join(nodes, "\n")), os.ModePerm); err != nil {
+		t.Fatal(err.Error())
+	}
+	return config
+}
+
+func (this *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	if atomic.AddInt32(&this.drop, -1) >= 0 {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+		return
+	}
+	if this.status != 0 {
+		w.WriteHeader(this.status)
+		return
+	}
+	encoding := wireEncodingForContentType(r.Header.Get("Content-Type"))
+	if encoding.ContentType() != JSON_CONTENT_TYPE && !this.acceptMsgpack {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var request CacheRequest
+	body, _ := ioutil.ReadAll(r.Body)
+	if this.authenticate != nil && !this.authenticate(r, body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("Content-Encoding") == GZIP_CONTENT_ENCODING {
+		atomic.AddInt32(&this.gzipRequests, 1)
+		body, _ = GzipCompressor{}.Decompress(body)
+	}
+	if err := encoding.Unmarshal(body, &request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	response := this.apply(strings.TrimPrefix(r.URL.Path, "/"), request)
+	data, _ := encoding.Marshal(response)
+	w.Header().Set("Content-Type", encoding.ContentType())
+	if this.gzipResponses && strings.Contains(r.Header.Get("Accept-Encoding"), GZIP_CONTENT_ENCODING) {
+		data, _ = GzipCompressor{}.Compress(data)
+		w.Header().Set("Content-Encoding", GZIP_CONTENT_ENCODING)
+	}
+	w.Write(data)
+}
+
+func (this *fakeServer) apply(op string, request CacheRequest) CacheResponse {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	gobj := request.Gobj
+	obj, exists := this.objects[gobj.Key]
+	if exists && !obj.expires.IsZero() && time.Now().After(obj.expires) {
+		delete(this.objects, gobj.Key)
+		obj, exists = nil, false
+	}
+
+	ok := func(obj *fakeObject) CacheResponse {
+		response := CacheResponse{Status: 1, Message: "OK", Gobj: GhostObject{Key: gobj.Key, TTL: -1}}
+		if obj != nil {
+			response.Gobj.Value = obj.value
+			response.Gobj.Version = obj.version
+			if !obj.expires.IsZero() {
+				response.Gobj.TTL = int(time.Until(obj.expires)/time.Second) + 1
+			}
+		}
+		return response
+	}
+	miss := CacheResponse{Status: 0, Message: CACHE_MISS, Gobj: GhostObject{Key: gobj.Key}}
+	store := func(value interface{}) *fakeObject {
+		this.version++
+		obj := &fakeObject{value: value, version: this.version}
+		if gobj.TTL >= 0 {
+			obj.expires = time.Now().Add(time.Duration(gobj.TTL) * time.Second)
+		}
+		this.objects[gobj.Key] = obj
+		return obj
+	}
+
+	if op == "get" {
+		if this.reads == nil {
+			this.reads = make(map[string]int)
+		}
+		this.reads[gobj.Key]++
+	}
+
+	switch op {
+	case "get", "gets", "ttl":
+		if !exists {
+			return miss
+		}
+		return ok(obj)
+	case "put":
+		return ok(store(gobj.Value))
+	case "add":
+		if exists {
+			return CacheResponse{Status: 0, Message: "KEY_EXISTS"}
+		}
+		return ok(store(gobj.Value))
+	case "cas":
+		if !exists {
+			return miss
+		}
+		if obj.version != gobj.Version {
+			return CacheResponse{Status: 0, Message: CAS_CONFLICT}
+		}
+		return ok(store(gobj.Value))
+	case "incr":
+		var current int64
+		if exists {
+			var isNumber bool
+			if current, isNumber = toInt64(obj.value); !isNumber {
+				return CacheResponse{Status: 0, Message: NOT_NUMERIC}
+			}
+		}
+		return ok(store(float64(current + request.Delta)))
+	case "touch", "getAndTouch":
+		if !exists {
+			return miss
+		}
+		obj.expires = time.Time{}
+		if gobj.TTL >= 0 {
+			obj.expires = time.Now().Add(time.Duration(gobj.TTL) * time.Second)
+		}
+		return ok(obj)
+	case "delete":
+		delete(this.objects, gobj.Key)
+		return ok(nil)
+	case "flush":
+		this.objects = make(map[string]*fakeObject)
+		return ok(nil)
+	case "ping":
+		return ok(nil)
+	}
+	return CacheResponse{Status: 0, Error: "unknown operation " + op}
+}
@@ -34,6 +34,7 @@ type GhostObject struct {
 	Key   string
 	Value interface{}
 	TTL int
+	Version uint64
 }
 
 func NewGhostObject(params CacheRequestParams) GhostObject {
@@ -41,5 +42,6 @@ func NewGhostObject(params CacheRequestParams) GhostObject {
 		Key: params.Key,
 		Value: params.Value,
 		TTL: params.TTL,
+		Version: params.Version,
 	}
 }
@@ -1,3 +1,10 @@
 module github.com/jakegrog/ghostdb-sdk-golang
 
-go 1.13
+go 1.19
+
+require (
+	github.com/klauspost/compress v1.15.15
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
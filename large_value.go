@@ -0,0 +1,284 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_CHUNK_SIZE = 512 * 1024
+	// Chunks outlive their manifest slightly so that a manifest is never
+	// readable after the chunks it points to have expired.
+	LARGE_CHUNK_TTL_GRACE = time.Minute
+	LARGE_MANIFEST_VERSION = 1
+)
+
+const (
+	INVALID_CHUNK_SIZE_ERROR = "Chunk size must be at least 1 byte"
+	NOT_A_LARGE_VALUE_ERROR = "Value was not written with PutLarge"
+)
+
+// largeManifest is stored under the caller's key and describes the chunks
+// that make up a large value. Chunk keys include the manifest's ID, so an
+// overwrite never disturbs the chunks a concurrent reader is fetching.
+type largeManifest struct {
+	Large     int      `json:"_gdbLarge"`
+	ID        string   `json:"id"`
+	Codec     string   `json:"codec,omitempty"`
+	Size      int64    `json:"size"`
+	Checksums []string `json:"sums"`
+}
+
+// SetChunkSize sets the size in bytes of the chunks PutLarge splits values
+// into. It should be comfortably below the server's object size limit.
+func (this *Cache) SetChunkSize(size int) error {
+	if size < 1 {
+		return errors.New(INVALID_CHUNK_SIZE_ERROR)
+	}
+	this.chunkSize = size
+	return nil
+}
+
+// PutLarge stores a value too big for a single object by encoding it,
+// splitting it into chunks spread across the ring, and writing a manifest
+// under key once every chunk is in place. Chunks of any value previously
+// stored at key are removed afterwards.
+func (this *Cache) PutLarge(key string, value interface{}, ttl time.Duration) error {
+	codec := this.codec
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	writer, err := this.newLargeWriter(key, codec.Name(), ttl)
+	if err != nil {
+		return err
+	}
+	for offset := 0; offset < len(data); offset += this.chunkSize {
+		end := offset + this.chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := writer.writeChunk(data[offset:end]); err != nil {
+			writer.abort()
+			return err
+		}
+	}
+	return writer.commit()
+}
+
+// GetLarge returns a value stored with PutLarge. found is false if the
+// manifest or any of its chunks is missing.
+func (this *Cache) GetLarge(key string) (interface{}, bool, error) {
+	var value interface{}
+	found, err := this.GetLargeInto(key, &value)
+	return value, found, err
+}
+
+// GetLargeInto decodes a value stored with PutLarge into dst after
+// verifying the checksum of every chunk.
+func (this *Cache) GetLargeInto(key string, dst interface{}) (bool, error) {
+	manifest, found, err := this.getManifest(key)
+	if err != nil || !found {
+		return false, err
+	}
+
+	if manifest.Size < 0 {
+		return false, fmt.Errorf("large value at key %q has a negative size in its manifest", key)
+	}
+	// The manifest is read from the cluster, so its size is only trusted
+	// for preallocation as far as the chunks it lists could plausibly go.
+	size := manifest.Size
+	if limit := int64(len(manifest.Checksums)) * int64(this.chunkSize); size > limit {
+		size = limit
+	}
+	var data bytes.Buffer
+	data.Grow(int(size))
+	for i := range manifest.Checksums {
+		chunk, found, err := this.getChunk(key, manifest, i)
+		if err != nil || !found {
+			return false, err
+		}
+		data.Write(chunk)
+	}
+	if int64(data.Len()) != manifest.Size {
+		return false, fmt.Errorf("large value at key %q is %d bytes, manifest says %d", key, data.Len(), manifest.Size)
+	}
+
+	codec, err := lookupCodec(manifest.Codec)
+	if err != nil {
+		return false, err
+	}
+	return true, codec.Unmarshal(data.Bytes(), dst)
+}
+
+// DeleteLarge removes a value stored with PutLarge along with its chunks.
+func (this *Cache) DeleteLarge(key string) error {
+	manifest, found, err := this.getManifest(key)
+	if err != nil {
+		return err
+	}
+	if _, err := this.Delete(key); err != nil {
+		return err
+	}
+	if found {
+		return this.deleteChunks(key, manifest)
+	}
+	return nil
+}
+
+// largeWriter writes the chunks of a large value one at a time, so that
+// callers never need to hold more than a chunk in memory.
+type largeWriter struct {
+	cache    *Cache
+	key      string
+	ttl      time.Duration
+	chunkTTL time.Duration
+	manifest *largeManifest
+}
+
+func (this *Cache) newLargeWriter(key string, codec string, ttl time.Duration) (*largeWriter, error) {
+	if ttl == DefaultTTL {
+		ttl = this.defaultTTL
+	}
+	if err := validateTTL(ttl); err != nil {
+		return nil, err
+	}
+	chunkTTL := ttl
+	if ttl != NoExpiry {
+		chunkTTL = ttl + LARGE_CHUNK_TTL_GRACE
+		if chunkTTL > MAX_TTL {
+			chunkTTL = MAX_TTL
+		}
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &largeWriter{
+		cache:    this,
+		key:      key,
+		ttl:      ttl,
+		chunkTTL: chunkTTL,
+		manifest: &largeManifest{
+			Large: LARGE_MANIFEST_VERSION,
+			ID:    hex.EncodeToString(id),
+			Codec: codec,
+		},
+	}, nil
+}
+
+func (this *largeWriter) writeChunk(chunk []byte) error {
+	index := len(this.manifest.Checksums)
+	if _, err := this.cache.Put(chunkKey(this.key, this.manifest.ID, index), chunk, this.chunkTTL); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(chunk)
+	this.manifest.Checksums = append(this.manifest.Checksums, hex.EncodeToString(sum[:]))
+	this.manifest.Size += int64(len(chunk))
+	return nil
+}
+
+// commit publishes the manifest and then removes the chunks of whatever
+// value it replaced.
+func (this *largeWriter) commit() error {
+	previous, hadPrevious, err := this.cache.getManifest(this.key)
+	if err != nil {
+		hadPrevious = false
+	}
+	if _, err := this.cache.Put(this.key, this.manifest, this.ttl); err != nil {
+		return err
+	}
+	if hadPrevious && previous.ID != this.manifest.ID {
+		return this.cache.deleteChunks(this.key, previous)
+	}
+	return nil
+}
+
+// abort removes any chunks written so far.
+func (this *largeWriter) abort() error {
+	return this.cache.deleteChunks(this.key, this.manifest)
+}
+
+func (this *Cache) getManifest(key string) (*largeManifest, bool, error) {
+	response, env, err := this.get(context.Background(), key, true)
+	if err != nil {
+		return nil, false, err
+	}
+	if IsNotCached(response) || IsKnownAbsent(response) {
+		return nil, false, nil
+	}
+	var manifest largeManifest
+	if err := decodeInto(response.Gobj.Value, env, &manifest); err != nil || manifest.Large == 0 {
+		return nil, false, errors.New(NOT_A_LARGE_VALUE_ERROR)
+	}
+	return &manifest, true, nil
+}
+
+// getChunk fetches a chunk and verifies it against the manifest.
+func (this *Cache) getChunk(key string, manifest *largeManifest, index int) ([]byte, bool, error) {
+	var chunk []byte
+	found, err := this.GetInto(chunkKey(key, manifest.ID, index), &chunk)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	sum := sha256.Sum256(chunk)
+	if hex.EncodeToString(sum[:]) != manifest.Checksums[index] {
+		return nil, false, fmt.Errorf("chunk %d of large value at key %q failed its checksum", index, key)
+	}
+	return chunk, true, nil
+}
+
+func (this *Cache) deleteChunks(key string, manifest *largeManifest) error {
+	for i := range manifest.Checksums {
+		if _, err := this.Delete(chunkKey(key, manifest.ID, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func chunkKey(key string, id string, index int) string {
+	return key + "#chunk:" + id + ":" + strconv.Itoa(index)
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPutLargeRoundTrip(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetChunkSize(64)
+	report := strings.Repeat("quarterly figures ", 50)
+
+	err := cache.PutLarge("report", report, NoExpiry)
+	AssertEqual(t, err, nil, "")
+	// The manifest plus ceil(len(json)/64) chunks.
+	AssertEqual(t, len(fake.objects), 1+(len(report)+2+63)/64, "")
+
+	value, found, err := cache.GetLarge("report")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, found, true, "")
+	AssertEqual(t, value, report, "")
+}
+
+func TestPutLargeOverwriteRemovesOldChunks(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetChunkSize(16)
+
+	cache.PutLarge("report", strings.Repeat("a", 100), NoExpiry)
+	cache.PutLarge("report", "short", NoExpiry)
+	AssertEqual(t, len(fake.objects), 2, "")
+
+	value, _, _ := cache.GetLarge("report")
+	AssertEqual(t, value, "short", "")
+
+	err := cache.DeleteLarge("report")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, len(fake.objects), 0, "")
+}
+
+func TestGetLargeVerifiesChecksums(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetChunkSize(16)
+	cache.PutLarge("report", strings.Repeat("a", 100), NoExpiry)
+
+	manifest, _, _ := cache.getManifest("report")
+	fake.objects[chunkKey("report", manifest.ID, 2)].value = roundTrip(t, []byte("bbbbbbbbbbbbbbbb"))
+
+	_, _, err := cache.GetLarge("report")
+	AssertEqual(t, err != nil, true, "")
+}
+
+func TestGetLargeMissingChunk(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetChunkSize(16)
+	cache.PutLarge("report", strings.Repeat("a", 100), NoExpiry)
+
+	manifest, _, _ := cache.getManifest("report")
+	delete(fake.objects, chunkKey("report", manifest.ID, 0))
+
+	_, found, err := cache.GetLarge("report")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, found, false, "")
+}
+
+func TestGetLargeRejectsPlainValues(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.Put("report", "small", NoExpiry)
+
+	_, _, err := cache.GetLarge("report")
+	AssertEqual(t, err != nil, true, "")
+}
+
+func TestGetLargeRejectsBadManifestSizes(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetChunkSize(16)
+	cache.PutLarge("report", strings.Repeat("a", 100), NoExpiry)
+
+	for _, size := range []int64{-1, 1 << 60} {
+		manifest, _, _ := cache.getManifest("report")
+		manifest.Size = size
+		fake.objects["report"].value = roundTrip(t, manifest)
+
+		_, _, err := cache.GetLarge("report")
+		AssertEqual(t, err != nil, true, "")
+	}
+}
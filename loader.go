@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	NEGATIVE_STALE_WINDOW_ERROR = "Stale window must not be negative"
+	NEGATIVE_BETA_ERROR = "Early expiration beta must not be negative"
+)
+
+// Loader fetches a value from the system of record on a cache miss. Its
+// result may be shared by several callers, so ctx carries the values of
+// the caller's context but not its deadline or cancellation.
+type Loader func(ctx context.Context) (interface{}, error)
+
+// detachedContext carries the values of its parent, such as request
+// metadata, without its deadline or cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (this detachedContext) Value(key interface{}) interface{} {
+	return this.parent.Value(key)
+}
+
+// SetServeStale lets GetOrLoad keep returning an expired value for up to
+// window after its TTL while a single background refresh replaces it.
+// A window of 0 disables serving stale values.
+func (this *Cache) SetServeStale(window time.Duration) error {
+	if window < 0 {
+		return errors.New(NEGATIVE_STALE_WINDOW_ERROR)
+	}
+	this.staleWindow = window
+	return nil
+}
+
+// SetEarlyExpirationBeta enables probabilistic early recomputation in
+// GetOrLoad. Values record how long they took to load, and readers
+// approaching expiry occasionally refresh them before they lapse, so that
+// a hot key never expires for every caller at once. A beta of 1 is a good
+// default, larger values refresh earlier, and 0 disables the behaviour.
+func (this *Cache) SetEarlyExpirationBeta(beta float64) error {
+	if beta < 0 {
+		return errors.New(NEGATIVE_BETA_ERROR)
+	}
+	this.xfetchBeta = beta
+	return nil
+}
+
+// GetOrLoad returns the cached value for key, calling loader and storing
+// its result on a miss. If negative caching is enabled and loader returns
+// ErrNotFound, the key is marked absent and later calls return ErrNotFound
+// without calling loader. Concurrent misses for the same key within this
+// process share a single call to loader. If the value was loaded but
+// could not be stored, both the value and the error are returned.
+func (this *Cache) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader Loader) (interface{}, error) {
+	response, env, err := this.get(ctx, key, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsKnownAbsent(response) {
+		return nil, ErrNotFound
+	}
+	if response.Message != CACHE_MISS {
+		if env == nil {
+			return response.Gobj.Value, nil
+		}
+		now := time.Now()
+		if this.loads.InFlight(key) {
+			return response.Gobj.Value, nil
+		}
+		if env.isStale(now) {
+			// The refresh outlives this call, so it must not be cut short
+			// when ctx is.
+			go this.refresh(detachedContext{parent: ctx}, key, ttl, loader)
+		} else if env.shouldRefreshEarly(now, this.xfetchBeta, 1-rand.Float64()) {
+			if value, err := this.load(ctx, key, ttl, loader); err == nil {
+				return value, nil
+			}
+		}
+		return response.Gobj.Value, nil
+	}
+
+	return this.load(ctx, key, ttl, loader)
+}
+
+// refresh reloads key in the background. Nobody is waiting for the result,
+// so if loader panics the panic is dropped rather than taking down the
+// process, and the stale value stays in place for a later read to retry.
+func (this *Cache) refresh(ctx context.Context, key string, ttl time.Duration, loader Loader) {
+	defer func() {
+		recover()
+	}()
+	this.load(ctx, key, ttl, loader)
+}
+
+// loadResult is shared by every caller of one load, so that they all see
+// a failure to store the loaded value.
+type loadResult struct {
+	value    interface{}
+	storeErr error
+}
+
+func (this *Cache) load(ctx context.Context, key string, ttl time.Duration, loader Loader) (interface{}, error) {
+	loaderCtx := detachedContext{parent: ctx}
+	result, err, _ := this.loads.DoContext(ctx, key, func() (interface{}, error) {
+		start := time.Now()
+		value, err := loader(loaderCtx)
+		if errors.Is(err, ErrNotFound) && this.negativeMode != 0 {
+			this.MarkAbsent(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		storeErr := this.storeLoaded(loaderCtx, key, value, ttl, time.Since(start))
+		return loadResult{value: value, storeErr: storeErr}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	loaded := result.(loadResult)
+	return loaded.value, loaded.storeErr
+}
+
+// storeLoaded writes a freshly loaded value. When serving stale values is
+// enabled the value is kept for the stale window beyond its TTL, with the
+// real expiry recorded in the envelope. computeTime is recorded for early
+// expiration.
+func (this *Cache) storeLoaded(ctx context.Context, key string, value interface{}, ttl time.Duration, computeTime time.Duration) error {
+	if ttl == DefaultTTL {
+		ttl = this.defaultTTL
+	}
+	if (this.staleWindow == 0 && this.xfetchBeta == 0) || ttl == NoExpiry {
+		_, err := this.PutContext(ctx, key, value, ttl)
+		return err
+	}
+	if err := validateTTL(ttl); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	env := newValueEnvelope(value)
+	if this.staleWindow > 0 {
+		env.FreshUntil = now.Add(ttl).UnixNano()
+	}
+	if this.xfetchBeta > 0 {
+		env.Expiry = now.Add(ttl).UnixNano()
+		env.Delta = int64(computeTime)
+	}
+	physicalTTL := ttl + this.staleWindow
+	if physicalTTL > MAX_TTL {
+		physicalTTL = MAX_TTL
+	}
+	_, err := this.PutContext(ctx, key, env, physicalTTL)
+	return err
+}
@@ -0,0 +1,268 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	cache, _ := newTestCache(t)
+	calls := 0
+	loader := func(ctx context.Context) (interface{}, error) {
+		calls++
+		return "loaded", nil
+	}
+
+	value, err := cache.GetOrLoad(context.Background(), "key", NoExpiry, loader)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "loaded", "")
+	value, err = cache.GetOrLoad(context.Background(), "key", NoExpiry, loader)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "loaded", "")
+	AssertEqual(t, calls, 1, "")
+}
+
+func TestGetOrLoadLoaderPanic(t *testing.T) {
+	cache, _ := newTestCache(t)
+	func() {
+		defer func() {
+			AssertEqual(t, recover(), "boom", "")
+		}()
+		cache.GetOrLoad(context.Background(), "key", NoExpiry, func(ctx context.Context) (interface{}, error) {
+			panic("boom")
+		})
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		value, err := cache.GetOrLoad(context.Background(), "key", NoExpiry, func(ctx context.Context) (interface{}, error) {
+			return "loaded", nil
+		})
+		AssertEqual(t, err, nil, "")
+		AssertEqual(t, value, "loaded", "")
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetOrLoad hung after a loader panic")
+	}
+}
+
+func TestGetOrLoadLeaderCancellation(t *testing.T) {
+	cache, _ := newTestCache(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		// Cancelling the caller that started the load must not cancel it.
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return "loaded", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error)
+	go func() {
+		_, err := cache.GetOrLoad(ctx, "key", NoExpiry, loader)
+		leader <- err
+	}()
+	<-started
+	follower := make(chan interface{})
+	go func() {
+		value, _ := cache.GetOrLoad(context.Background(), "key", NoExpiry, loader)
+		follower <- value
+	}()
+	for !cache.loads.InFlight("key") {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	AssertEqual(t, <-leader, context.Canceled, "")
+	close(release)
+	AssertEqual(t, <-follower, "loaded", "")
+}
+
+func TestGetOrLoadSharesStoreError(t *testing.T) {
+	cache, _ := newTestCache(t)
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		<-release
+		return "loaded", nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// A negative TTL is rejected when the loaded value is stored.
+			value, err := cache.GetOrLoad(context.Background(), "key", -5*time.Second, loader)
+			AssertEqual(t, value, "loaded", "")
+			errs[i] = err
+		}(i)
+	}
+	for !cache.loads.InFlight("key") {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for _, err := range errs {
+		AssertEqual(t, err != nil, true, "")
+	}
+}
+
+func TestGetOrLoadNotFound(t *testing.T) {
+	cache, _ := newTestCache(t)
+	_, err := cache.GetOrLoad(context.Background(), "key", NoExpiry, func(ctx context.Context) (interface{}, error) {
+		return nil, ErrNotFound
+	})
+	AssertEqual(t, errors.Is(err, ErrNotFound), true, "")
+}
+
+// expireFreshness makes the value stored at key stale without waiting for
+// its TTL to pass.
+func expireFreshness(t *testing.T, fake *fakeServer, key string) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	env, _ := decodeEnvelope(roundTrip(t, fake.objects[key].value))
+	env.FreshUntil = time.Now().Add(-time.Second).UnixNano()
+	fake.objects[key].value = roundTrip(t, env)
+}
+
+func TestGetOrLoadServesStaleWhileRefreshing(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetServeStale(time.Hour)
+	_, err := cache.GetOrLoad(context.Background(), "key", time.Minute, func(ctx context.Context) (interface{}, error) {
+		return "v1", nil
+	})
+	AssertEqual(t, err, nil, "")
+	expireFreshness(t, fake, "key")
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "v2", nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := cache.GetOrLoad(context.Background(), "key", time.Minute, loader)
+			AssertEqual(t, err, nil, "")
+			AssertEqual(t, value, "v1", "")
+		}()
+	}
+	wg.Wait()
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		response, _ := cache.Get("key")
+		if response.Gobj.Value == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale value was never refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	AssertEqual(t, atomic.LoadInt32(&calls), int32(1), "")
+}
+
+func TestGetOrLoadStaleRefreshPanic(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetServeStale(time.Hour)
+	cache.GetOrLoad(context.Background(), "key", time.Minute, func(ctx context.Context) (interface{}, error) {
+		return "v1", nil
+	})
+	expireFreshness(t, fake, "key")
+
+	refreshed := make(chan struct{})
+	value, err := cache.GetOrLoad(context.Background(), "key", time.Minute, func(ctx context.Context) (interface{}, error) {
+		defer close(refreshed)
+		panic("boom")
+	})
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "v1", "")
+	<-refreshed
+	for cache.loads.InFlight("key") {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The process survived and the stale value is still served.
+	value, err = cache.GetOrLoad(context.Background(), "key", time.Minute, func(ctx context.Context) (interface{}, error) {
+		return "v2", nil
+	})
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "v1", "")
+}
+
+func TestGetOrLoadRefreshesEarly(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.SetEarlyExpirationBeta(1e12)
+	_, err := cache.GetOrLoad(context.Background(), "key", time.Minute, func(ctx context.Context) (interface{}, error) {
+		time.Sleep(time.Millisecond)
+		return "v1", nil
+	})
+	AssertEqual(t, err, nil, "")
+
+	// With so large a beta any recorded compute time puts the value well
+	// past its early expiry, so the next read reloads it.
+	value, err := cache.GetOrLoad(context.Background(), "key", time.Minute, func(ctx context.Context) (interface{}, error) {
+		return "v2", nil
+	})
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "v2", "")
+	response, _ := cache.Get("key")
+	AssertEqual(t, response.Gobj.Value, "v2", "")
+
+	cache.SetEarlyExpirationBeta(0)
+	value, _ = cache.GetOrLoad(context.Background(), "key", time.Minute, func(ctx context.Context) (interface{}, error) {
+		return "v3", nil
+	})
+	AssertEqual(t, value, "v2", "")
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Metadata is a set of headers sent with a request, such as tenant or
+// trace IDs for server-side auditing. Over HTTP each entry is a request
+// header; TCPTransport carries them in the request frame.
+type Metadata map[string]string
+
+type metadataKey struct{}
+
+// WithMetadata returns a context carrying md on top of any metadata ctx
+// already carries. Pass it to the Context variants of the Cache methods.
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	merged := Metadata{}
+	for name, value := range MetadataFromContext(ctx) {
+		merged[name] = value
+	}
+	for name, value := range md {
+		merged[name] = value
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// MetadataFromContext returns the metadata carried by ctx. The result must
+// not be modified.
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(metadataKey{}).(Metadata)
+	return md
+}
+
+// validate checks that every entry is a valid HTTP header, so that bad
+// metadata fails the request rather than the node it is sent to.
+func (this Metadata) validate() error {
+	for name, value := range this {
+		if !validHeaderName(name) {
+			return fmt.Errorf("metadata header name %q is not a valid HTTP header name", name)
+		}
+		if !validHeaderValue(value) {
+			return fmt.Errorf("metadata header %q has a value that is not a valid HTTP header value", name)
+		}
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is an RFC 7230 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue reports whether value has no control characters other
+// than horizontal tab, which rules out header injection through CR or LF.
+func validHeaderValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// HeaderRedactor returns the value to log in place of a header's value.
+type HeaderRedactor func(name, value string) string
+
+// RequestLogger is called after every request with the node it went to,
+// its type, its metadata after redaction and the error, if any.
+type RequestLogger func(node string, requestType string, md Metadata, err error)
+
+// SetDefaultHeaders sets metadata sent with every request. Metadata passed
+// through a context takes precedence over it. An error is returned if any
+// header isn't a valid HTTP header.
+func (this *Cache) SetDefaultHeaders(headers Metadata) error {
+	if err := headers.validate(); err != nil {
+		return err
+	}
+	defaults := Metadata{}
+	for name, value := range headers {
+		defaults[name] = value
+	}
+	this.defaultHeaders = defaults
+	return nil
+}
+
+// SetRequestLogger sets a logger called after every request. Pass nil to
+// stop logging.
+func (this *Cache) SetRequestLogger(logger RequestLogger) {
+	this.requestLogger = logger
+}
+
+// SetHeaderRedactor sets how metadata values are masked before they are
+// handed to the request logger, for example to hide tenant IDs.
+func (this *Cache) SetHeaderRedactor(redactor HeaderRedactor) {
+	this.headerRedactor = redactor
+}
+
+// requestContext adds the cache's default headers beneath any metadata ctx
+// already carries.
+func (this *Cache) requestContext(ctx context.Context) context.Context {
+	if len(this.defaultHeaders) == 0 {
+		return ctx
+	}
+	md := MetadataFromContext(ctx)
+	return WithMetadata(WithMetadata(ctx, this.defaultHeaders), md)
+}
+
+func (this *Cache) logRequest(ctx context.Context, node string, requestType string, err error) {
+	logger := this.requestLogger
+	if logger == nil {
+		return
+	}
+	logger(node, requestType, this.redact(MetadataFromContext(ctx)), err)
+}
+
+func (this *Cache) redact(md Metadata) Metadata {
+	redacted := Metadata{}
+	for name, value := range md {
+		if this.headerRedactor != nil {
+			value = this.headerRedactor(name, value)
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
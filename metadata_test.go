@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMetadataHeaders(t *testing.T) {
+	cache, fake := newTestCache(t)
+	var mu sync.Mutex
+	var headers http.Header
+	fake.authenticate = func(r *http.Request, body []byte) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		headers = r.Header.Clone()
+		return true
+	}
+	cache.SetDefaultHeaders(Metadata{"X-Tenant-Id": "tenant-7", "X-Client": "checkout"})
+
+	ctx := WithMetadata(context.Background(), Metadata{"X-Trace-Id": "trace-1"})
+	ctx = WithMetadata(ctx, Metadata{"X-Tenant-Id": "tenant-9"})
+	_, err := cache.PutContext(ctx, "key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, headers.Get("X-Trace-Id"), "trace-1", "")
+	AssertEqual(t, headers.Get("X-Tenant-Id"), "tenant-9", "")
+	AssertEqual(t, headers.Get("X-Client"), "checkout", "")
+
+	_, err = cache.Get("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, headers.Get("X-Trace-Id"), "", "")
+	AssertEqual(t, headers.Get("X-Tenant-Id"), "tenant-7", "")
+}
+
+func TestRequestLoggerRedactsMetadata(t *testing.T) {
+	cache, _ := newTestCache(t)
+	var logged []Metadata
+	var ops []string
+	cache.SetRequestLogger(func(node string, requestType string, md Metadata, err error) {
+		AssertEqual(t, err, nil, "")
+		ops = append(ops, requestType)
+		logged = append(logged, md)
+	})
+	cache.SetHeaderRedactor(func(name, value string) string {
+		if strings.EqualFold(name, "X-Tenant-Id") {
+			return "REDACTED"
+		}
+		return value
+	})
+
+	md := Metadata{"X-Tenant-Id": "tenant-7", "X-Trace-Id": "trace-1"}
+	_, err := cache.DeleteContext(WithMetadata(context.Background(), md), "key")
+	AssertEqual(t, err, nil, "")
+
+	AssertEqual(t, len(logged), 1, "")
+	AssertEqual(t, ops[0], "delete", "")
+	AssertEqual(t, logged[0]["X-Tenant-Id"], "REDACTED", "")
+	AssertEqual(t, logged[0]["X-Trace-Id"], "trace-1", "")
+	AssertEqual(t, md["X-Tenant-Id"], "tenant-7", "")
+}
+
+func TestCanceledContextDoesNotMarkNodeDead(t *testing.T) {
+	cache, _ := newTestCache(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := cache.GetContext(ctx, "key")
+	AssertEqual(t, err != nil, true, "")
+	AssertEqual(t, len(cache.getDeadServers()), 0, "")
+}
+
+func TestInvalidMetadataIsRejected(t *testing.T) {
+	cache, _ := newTestCache(t)
+	for _, md := range []Metadata{
+		{"X-Tenant ID": "acme"},
+		{"": "acme"},
+		{"X-Tenant": "acme\r\nX-Admin: true"},
+	} {
+		_, err := cache.GetContext(WithMetadata(context.Background(), md), "key")
+		var requestErr *RequestError
+		AssertEqual(t, errors.As(err, &requestErr), true, "")
+		AssertEqual(t, cache.SetDefaultHeaders(md) != nil, true, "")
+	}
+	AssertEqual(t, len(cache.getDeadServers()), 0, "")
+	AssertEqual(t, cache.SetDefaultHeaders(Metadata{"X-Tenant": "acme\tcorp"}), nil, "")
+}
+
+func TestMetadataReachesNamespaceAndTagReads(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.PutTagged("tagged", "value", NoExpiry, "catalog")
+	var mu sync.Mutex
+	var untraced []string
+	fake.authenticate = func(r *http.Request, body []byte) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("X-Trace-Id") != "trace-1" {
+			untraced = append(untraced, r.URL.Path)
+		}
+		return true
+	}
+	ctx := WithMetadata(context.Background(), Metadata{"X-Trace-Id": "trace-1"})
+
+	response, err := cache.GetContext(ctx, "tagged")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, response.Gobj.Value, "value", "")
+
+	billing := cache.Namespace("billing")
+	_, err = billing.PutContext(ctx, "invoice:42", "paid", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	_, err = billing.GetContext(ctx, "invoice:42")
+	AssertEqual(t, err, nil, "")
+	_, err = billing.GetOrLoad(ctx, "invoice:43", NoExpiry, func(ctx context.Context) (interface{}, error) {
+		return "due", nil
+	})
+	AssertEqual(t, err, nil, "")
+	value, err := billing.DecrContext(ctx, "balance", 5, NoExpiry)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, int64(-5), "")
+
+	AssertEqual(t, len(untraced), 0, strings.Join(untraced, ", "))
+}
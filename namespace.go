@@ -0,0 +1,270 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	NAMESPACE_GENERATION_PREFIX = "__ghostdb:ns:"
+)
+
+const (
+	EMPTY_NAMESPACE_ERROR = "Namespace name must not be empty"
+	CORRUPT_GENERATION_ERROR = "Namespace generation counter is not numeric"
+)
+
+// Namespace is a view of a Cache that transparently prefixes every key,
+// so that teams sharing a cluster can't collide and can flush only their
+// own keys.
+//
+// Keys are prefixed with the namespace's current generation, which is a
+// counter stored in the cache. Flush bumps the generation, leaving the old
+// keys unreachable until their TTLs lapse or the server evicts them.
+type Namespace struct {
+	cache *Cache
+	name  string
+}
+
+func (this *Cache) Namespace(name string) *Namespace {
+	return &Namespace{
+		cache: this,
+		name:  name,
+	}
+}
+
+func (this *Namespace) Name() string {
+	return this.name
+}
+
+// Flush logically removes every key in the namespace without touching
+// keys belonging to anyone else.
+func (this *Namespace) Flush() error {
+	if this.name == "" {
+		return errors.New(EMPTY_NAMESPACE_ERROR)
+	}
+	_, err := this.cache.Incr(this.generationKey(), 1, NoExpiry)
+	return err
+}
+
+func (this *Namespace) Get(key string) (CacheResponse, error) {
+	return this.GetContext(context.Background(), key)
+}
+
+func (this *Namespace) GetContext(ctx context.Context, key string) (CacheResponse, error) {
+	scoped, err := this.key(ctx, key)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	return this.cache.GetContext(ctx, scoped)
+}
+
+func (this *Namespace) Add(key string, value interface{}, ttl time.Duration) (CacheResponse, error) {
+	return this.AddContext(context.Background(), key, value, ttl)
+}
+
+func (this *Namespace) AddContext(ctx context.Context, key string, value interface{}, ttl time.Duration) (CacheResponse, error) {
+	scoped, err := this.key(ctx, key)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	return this.cache.AddContext(ctx, scoped, value, ttl)
+}
+
+func (this *Namespace) Put(key string, value interface{}, ttl time.Duration) (CacheResponse, error) {
+	return this.PutContext(context.Background(), key, value, ttl)
+}
+
+func (this *Namespace) PutContext(ctx context.Context, key string, value interface{}, ttl time.Duration) (CacheResponse, error) {
+	scoped, err := this.key(ctx, key)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	return this.cache.PutContext(ctx, scoped, value, ttl)
+}
+
+func (this *Namespace) Delete(key string) (CacheResponse, error) {
+	return this.DeleteContext(context.Background(), key)
+}
+
+func (this *Namespace) DeleteContext(ctx context.Context, key string) (CacheResponse, error) {
+	scoped, err := this.key(ctx, key)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	return this.cache.DeleteContext(ctx, scoped)
+}
+
+func (this *Namespace) Gets(key string) (CacheResponse, error) {
+	return this.GetsContext(context.Background(), key)
+}
+
+func (this *Namespace) GetsContext(ctx context.Context, key string) (CacheResponse, error) {
+	scoped, err := this.key(ctx, key)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	return this.cache.GetsContext(ctx, scoped)
+}
+
+func (this *Namespace) CompareAndSwap(key string, value interface{}, version uint64, ttl time.Duration) (CacheResponse, error) {
+	return this.CompareAndSwapContext(context.Background(), key, value, version, ttl)
+}
+
+func (this *Namespace) CompareAndSwapContext(ctx context.Context, key string, value interface{}, version uint64, ttl time.Duration) (CacheResponse, error) {
+	scoped, err := this.key(ctx, key)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	return this.cache.CompareAndSwapContext(ctx, scoped, value, version, ttl)
+}
+
+func (this *Namespace) Update(key string, fn func(old interface{}) interface{}) (CacheResponse, error) {
+	scoped, err := this.key(context.Background(), key)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	return this.cache.Update(scoped, fn)
+}
+
+func (this *Namespace) Incr(key string, delta int64, ttl time.Duration) (int64, error) {
+	return this.IncrContext(context.Background(), key, delta, ttl)
+}
+
+func (this *Namespace) IncrContext(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	scoped, err := this.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return this.cache.IncrContext(ctx, scoped, delta, ttl)
+}
+
+func (this *Namespace) Decr(key string, delta int64, ttl time.Duration) (int64, error) {
+	return this.DecrContext(context.Background(), key, delta, ttl)
+}
+
+func (this *Namespace) DecrContext(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	scoped, err := this.key(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return this.cache.DecrContext(ctx, scoped, delta, ttl)
+}
+
+func (this *Namespace) Touch(key string, ttl time.Duration) (CacheResponse, error) {
+	return this.TouchContext(context.Background(), key, ttl)
+}
+
+func (this *Namespace) TouchContext(ctx context.Context, key string, ttl time.Duration) (CacheResponse, error) {
+	scoped, err := this.key(ctx, key)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	return this.cache.TouchContext(ctx, scoped, ttl)
+}
+
+func (this *Namespace) GetAndTouch(key string, ttl time.Duration) (CacheResponse, error) {
+	return this.GetAndTouchContext(context.Background(), key, ttl)
+}
+
+func (this *Namespace) GetAndTouchContext(ctx context.Context, key string, ttl time.Duration) (CacheResponse, error) {
+	scoped, err := this.key(ctx, key)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	return this.cache.GetAndTouchContext(ctx, scoped, ttl)
+}
+
+func (this *Namespace) TTL(key string) (time.Duration, bool, error) {
+	return this.TTLContext(context.Background(), key)
+}
+
+func (this *Namespace) TTLContext(ctx context.Context, key string) (time.Duration, bool, error) {
+	scoped, err := this.key(ctx, key)
+	if err != nil {
+		return 0, false, err
+	}
+	return this.cache.TTLContext(ctx, scoped)
+}
+
+func (this *Namespace) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader Loader) (interface{}, error) {
+	scoped, err := this.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return this.cache.GetOrLoad(ctx, scoped, ttl, loader)
+}
+
+func (this *Namespace) MarkAbsent(key string) error {
+	scoped, err := this.key(context.Background(), key)
+	if err != nil {
+		return err
+	}
+	return this.cache.MarkAbsent(scoped)
+}
+
+// key maps a caller's key to its location under the namespace's current
+// generation. The generation is always read from the cluster so that a
+// flush by another client takes effect immediately. It is a bare counter
+// written by Incr, so it is read without opening it as a sealed value.
+func (this *Namespace) key(ctx context.Context, key string) (string, error) {
+	if this.name == "" {
+		return "", errors.New(EMPTY_NAMESPACE_ERROR)
+	}
+	response, err := this.cache.getRemote(ctx, this.generationKey())
+	if err != nil {
+		return "", err
+	}
+
+	var generation int64
+	if response.Message != CACHE_MISS {
+		var ok bool
+		generation, ok = toInt64(response.Gobj.Value)
+		if !ok {
+			return "", errors.New(CORRUPT_GENERATION_ERROR)
+		}
+	}
+	return scopedKey(this.name, generation, key), nil
+}
+
+func (this *Namespace) generationKey() string {
+	return NAMESPACE_GENERATION_PREFIX + this.name
+}
+
+// scopedKey length-prefixes the namespace so that namespaces containing the
+// separator can't produce the same keys as one another.
+func scopedKey(namespace string, generation int64, key string) string {
+	return strconv.Itoa(len(namespace)) + ":" + namespace + ":" + strconv.FormatInt(generation, 10) + ":" + key
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScopedKey(t *testing.T) {
+	AssertEqual(t, scopedKey("billing", 0, "invoice:42"), "7:billing:0:invoice:42", "")
+	AssertEqual(t, scopedKey("billing", 3, "invoice:42"), "7:billing:3:invoice:42", "")
+
+	// A namespace containing the separator must not alias another's keys.
+	AssertEqual(t, scopedKey("billing:0", 0, "x") == scopedKey("billing", 0, "0:x"), false, "")
+}
+
+func TestNamespaceRequiresName(t *testing.T) {
+	cache := &Cache{}
+	_, err := cache.Namespace("").Get("invoice:42")
+	AssertEqual(t, err != nil, true, "")
+	AssertEqual(t, cache.Namespace("").Flush() != nil, true, "")
+}
+
+func TestNamespaceFlush(t *testing.T) {
+	cache, _ := newTestCache(t)
+	billing := cache.Namespace("billing")
+	search := cache.Namespace("search")
+
+	billing.Put("invoice:42", "paid", NoExpiry)
+	search.Put("invoice:42", "indexed", NoExpiry)
+
+	response, _ := billing.Get("invoice:42")
+	AssertEqual(t, response.Gobj.Value, "paid", "")
+
+	err := billing.Flush()
+	AssertEqual(t, err, nil, "")
+
+	response, _ = billing.Get("invoice:42")
+	AssertEqual(t, response.Message, CACHE_MISS, "")
+	response, _ = search.Get("invoice:42")
+	AssertEqual(t, response.Gobj.Value, "indexed", "")
+}
+
+func TestNamespaceFlushWithSigningAndEncryption(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.SetSigningKey([]byte("tenant-7-secret"))
+	cache.SetEncryption(testKeyring(t, "2020-01"))
+	billing := cache.Namespace("billing")
+
+	_, err := billing.Put("invoice:42", "paid", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, billing.Flush(), nil, "")
+
+	response, err := billing.Get("invoice:42")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, response.Message, CACHE_MISS, "")
+
+	_, err = billing.Put("invoice:42", "refunded", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	response, err = billing.Get("invoice:42")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, response.Gobj.Value, "refunded", "")
+}
+
+func TestNamespaceDecrRejectsMinInt64(t *testing.T) {
+	cache, _ := newTestCache(t)
+	billing := cache.Namespace("billing")
+
+	_, err := billing.Decr("counter", math.MinInt64, NoExpiry)
+	AssertEqual(t, err != nil, true, "")
+	value, err := billing.Decr("counter", 2, NoExpiry)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, int64(-2), "")
+}
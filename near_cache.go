@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	INVALID_NEAR_CACHE_SIZE_ERROR = "Near-cache size must be at least 1"
+	INVALID_NEAR_CACHE_TTL_ERROR = "Near-cache TTL must be positive"
+)
+
+// NearCacheStats reports the effectiveness of the in-process near-cache.
+type NearCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+// nearCache is a size and TTL bounded LRU of recent Get responses kept in
+// front of the cluster.
+type nearCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[string]*list.Element
+	order      *list.List
+	stats      NearCacheStats
+}
+
+type nearCacheEntry struct {
+	key      string
+	response CacheResponse
+	env      *valueEnvelope
+	expires  time.Time
+}
+
+func newNearCache(maxEntries int, ttl time.Duration) *nearCache {
+	return &nearCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (this *nearCache) Get(key string) (CacheResponse, *valueEnvelope, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	elem, ok := this.entries[key]
+	if !ok {
+		this.stats.Misses++
+		return CacheResponse{}, nil, false
+	}
+	entry := elem.Value.(*nearCacheEntry)
+	if time.Now().After(entry.expires) {
+		this.removeElement(elem)
+		this.stats.Misses++
+		return CacheResponse{}, nil, false
+	}
+	this.order.MoveToFront(elem)
+	this.stats.Hits++
+	return entry.response, entry.env, true
+}
+
+// Set stores a response, expiring it after the near-cache TTL or the
+// remaining server side TTL, whichever comes first.
+func (this *nearCache) Set(key string, response CacheResponse, env *valueEnvelope) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	ttl := this.ttl
+	if response.Gobj.TTL > 0 {
+		if remaining := time.Duration(response.Gobj.TTL) * time.Second; remaining < ttl {
+			ttl = remaining
+		}
+	}
+	entry := &nearCacheEntry{key: key, response: response, env: env, expires: time.Now().Add(ttl)}
+
+	if elem, ok := this.entries[key]; ok {
+		elem.Value = entry
+		this.order.MoveToFront(elem)
+		return
+	}
+	this.entries[key] = this.order.PushFront(entry)
+	for this.order.Len() > this.maxEntries {
+		this.removeElement(this.order.Back())
+		this.stats.Evictions++
+	}
+}
+
+func (this *nearCache) Delete(key string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if elem, ok := this.entries[key]; ok {
+		this.removeElement(elem)
+	}
+}
+
+func (this *nearCache) Clear() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.entries = make(map[string]*list.Element)
+	this.order.Init()
+}
+
+func (this *nearCache) Stats() NearCacheStats {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	stats := this.stats
+	stats.Size = this.order.Len()
+	return stats
+}
+
+func (this *nearCache) removeElement(elem *list.Element) {
+	this.order.Remove(elem)
+	delete(this.entries, elem.Value.(*nearCacheEntry).key)
+}
+
+// EnableNearCache keeps up to maxEntries recently read keys in process for
+// at most ttl, so repeated reads of hot keys skip the network. Entries are
+// dropped when this client writes or deletes the key, but writes from
+// other clients are only seen once the entry expires.
+func (this *Cache) EnableNearCache(maxEntries int, ttl time.Duration) error {
+	if maxEntries < 1 {
+		return errors.New(INVALID_NEAR_CACHE_SIZE_ERROR)
+	}
+	if ttl <= 0 {
+		return errors.New(INVALID_NEAR_CACHE_TTL_ERROR)
+	}
+	this.nearCache = newNearCache(maxEntries, ttl)
+	return nil
+}
+
+func (this *Cache) DisableNearCache() {
+	this.nearCache = nil
+}
+
+// NearCacheStats returns hit and miss counts for the near-cache.
+func (this *Cache) NearCacheStats() NearCacheStats {
+	if this.nearCache == nil {
+		return NearCacheStats{}
+	}
+	return this.nearCache.Stats()
+}
+
+func (this *Cache) invalidateLocal(key string) {
+	if this.nearCache != nil {
+		this.nearCache.Delete(key)
+	}
+	if this.absentKeys != nil {
+		this.absentKeys.Delete(key)
+	}
+}
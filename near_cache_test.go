@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNearCacheHitAndMiss(t *testing.T) {
+	near := newNearCache(2, time.Minute)
+
+	_, _, ok := near.Get("Ireland")
+	AssertEqual(t, ok, false, "")
+
+	near.Set("Ireland", CacheResponse{Gobj: GhostObject{Key: "Ireland", Value: "Dublin", TTL: -1}}, nil)
+	response, _, ok := near.Get("Ireland")
+	AssertEqual(t, ok, true, "")
+	AssertEqual(t, response.Gobj.Value, "Dublin", "")
+
+	stats := near.Stats()
+	AssertEqual(t, stats.Hits, uint64(1), "")
+	AssertEqual(t, stats.Misses, uint64(1), "")
+	AssertEqual(t, stats.Size, 1, "")
+}
+
+func TestNearCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	near := newNearCache(2, time.Minute)
+
+	near.Set("a", CacheResponse{}, nil)
+	near.Set("b", CacheResponse{}, nil)
+	near.Get("a")
+	near.Set("c", CacheResponse{}, nil)
+
+	_, _, ok := near.Get("b")
+	AssertEqual(t, ok, false, "")
+	_, _, ok = near.Get("a")
+	AssertEqual(t, ok, true, "")
+	AssertEqual(t, near.Stats().Evictions, uint64(1), "")
+}
+
+func TestNearCacheExpiry(t *testing.T) {
+	near := newNearCache(10, time.Millisecond)
+
+	near.Set("a", CacheResponse{}, nil)
+	time.Sleep(5 * time.Millisecond)
+	_, _, ok := near.Get("a")
+	AssertEqual(t, ok, false, "")
+	AssertEqual(t, near.Stats().Size, 0, "")
+}
+
+func TestNearCacheInvalidation(t *testing.T) {
+	cache := &Cache{}
+	cache.EnableNearCache(10, time.Minute)
+
+	cache.nearCache.Set("a", CacheResponse{}, nil)
+	cache.invalidateLocal("a")
+	_, _, ok := cache.nearCache.Get("a")
+	AssertEqual(t, ok, false, "")
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	KNOWN_ABSENT = "KNOWN_ABSENT"
+)
+
+const (
+	// Remember absent keys in this process only.
+	NEGATIVE_CACHE_LOCAL = 1 << iota
+	// Store a sentinel in GhostDB so other clients see the key as absent.
+	NEGATIVE_CACHE_REMOTE
+)
+
+const (
+	NEGATIVE_CACHE_MAX_ENTRIES = 10000
+)
+
+const (
+	NOT_FOUND_ERROR = "Key does not exist in the system of record"
+	INVALID_NEGATIVE_TTL_ERROR = "Negative cache TTL must be positive"
+	INVALID_NEGATIVE_MODE_ERROR = "Negative cache mode must be NEGATIVE_CACHE_LOCAL, NEGATIVE_CACHE_REMOTE or both"
+	NEGATIVE_CACHE_DISABLED_ERROR = "Negative caching is not enabled"
+)
+
+// ErrNotFound is returned by loaders to report that a key does not exist
+// in the system of record, and by GetOrLoad for keys known to be absent.
+var ErrNotFound = errors.New(NOT_FOUND_ERROR)
+
+// EnableNegativeCaching remembers keys marked absent for ttl, locally,
+// in the cluster, or both depending on mode.
+func (this *Cache) EnableNegativeCaching(ttl time.Duration, mode int) error {
+	if ttl <= 0 {
+		return errors.New(INVALID_NEGATIVE_TTL_ERROR)
+	}
+	if mode == 0 || mode&^(NEGATIVE_CACHE_LOCAL|NEGATIVE_CACHE_REMOTE) != 0 {
+		return errors.New(INVALID_NEGATIVE_MODE_ERROR)
+	}
+	this.negativeTTL = ttl
+	this.negativeMode = mode
+	this.absentKeys = nil
+	if mode&NEGATIVE_CACHE_LOCAL != 0 {
+		this.absentKeys = newNearCache(NEGATIVE_CACHE_MAX_ENTRIES, ttl)
+	}
+	return nil
+}
+
+// MarkAbsent records that key does not exist in the system of record, so
+// that Get reports it as known absent until the negative TTL lapses.
+func (this *Cache) MarkAbsent(key string) error {
+	if this.negativeMode == 0 {
+		return errors.New(NEGATIVE_CACHE_DISABLED_ERROR)
+	}
+	if this.negativeMode&NEGATIVE_CACHE_REMOTE != 0 {
+		env := newValueEnvelope(nil)
+		env.Absent = true
+		if _, err := this.Put(key, env, this.negativeTTL); err != nil {
+			return err
+		}
+	}
+	if this.absentKeys != nil {
+		this.absentKeys.Set(key, knownAbsentResponse(key), nil)
+	}
+	return nil
+}
+
+// IsKnownAbsent reports whether the response is for a key previously
+// marked absent with MarkAbsent.
+func IsKnownAbsent(response CacheResponse) bool {
+	return response.Message == KNOWN_ABSENT
+}
+
+// IsNotCached reports whether the cache holds nothing for the key, so the
+// system of record must be consulted.
+func IsNotCached(response CacheResponse) bool {
+	return response.Message == CACHE_MISS
+}
+
+func knownAbsentResponse(key string) CacheResponse {
+	return CacheResponse{
+		Gobj:    GhostObject{Key: key, TTL: -1},
+		Status:  1,
+		Message: KNOWN_ABSENT,
+	}
+}
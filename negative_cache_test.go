@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMarkAbsentLocal(t *testing.T) {
+	cache := &Cache{}
+	err := cache.EnableNegativeCaching(time.Minute, NEGATIVE_CACHE_LOCAL)
+	AssertEqual(t, err, nil, "")
+
+	err = cache.MarkAbsent("missing")
+	AssertEqual(t, err, nil, "")
+
+	response, err := cache.Get("missing")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, IsKnownAbsent(response), true, "")
+	AssertEqual(t, IsNotCached(response), false, "")
+
+	// A write from this client clears the absent marker.
+	cache.invalidateLocal("missing")
+	_, _, ok := cache.absentKeys.Get("missing")
+	AssertEqual(t, ok, false, "")
+}
+
+func TestEnableNegativeCachingValidation(t *testing.T) {
+	cache := &Cache{}
+	AssertEqual(t, cache.EnableNegativeCaching(0, NEGATIVE_CACHE_LOCAL) != nil, true, "")
+	AssertEqual(t, cache.EnableNegativeCaching(time.Second, 0) != nil, true, "")
+	AssertEqual(t, cache.EnableNegativeCaching(time.Second, 8) != nil, true, "")
+	AssertEqual(t, cache.MarkAbsent("missing") != nil, true, "")
+}
+
+func TestAbsentEnvelope(t *testing.T) {
+	env := newValueEnvelope(nil)
+	env.Absent = true
+
+	decoded, ok := decodeEnvelope(roundTrip(t, env))
+	AssertEqual(t, ok, true, "")
+	AssertEqual(t, decoded.Absent, true, "")
+}
+
+func TestGetOrLoadCachesWrappedNotFound(t *testing.T) {
+	cache, _ := newTestCache(t)
+	AssertEqual(t, cache.EnableNegativeCaching(time.Minute, NEGATIVE_CACHE_LOCAL), nil, "")
+
+	calls := 0
+	loader := func(ctx context.Context) (interface{}, error) {
+		calls++
+		return nil, fmt.Errorf("user 42: %w", ErrNotFound)
+	}
+	_, err := cache.GetOrLoad(context.Background(), "user:42", time.Minute, loader)
+	AssertEqual(t, errors.Is(err, ErrNotFound), true, "")
+	_, err = cache.GetOrLoad(context.Background(), "user:42", time.Minute, loader)
+	AssertEqual(t, err, ErrNotFound, "")
+	AssertEqual(t, calls, 1, "")
+}
+
+func TestUpdateReplacesRemoteAbsentSentinel(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.EnableNegativeCaching(time.Minute, NEGATIVE_CACHE_REMOTE)
+	AssertEqual(t, cache.MarkAbsent("key"), nil, "")
+
+	current, err := cache.Gets("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, IsKnownAbsent(current), true, "")
+	AssertEqual(t, current.Gobj.Version > 0, true, "")
+
+	var old interface{} = "unset"
+	start := time.Now()
+	_, err = cache.Update("key", func(value interface{}) interface{} {
+		old = value
+		return "found"
+	})
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, old, nil, "")
+	AssertEqual(t, time.Since(start) < UPDATE_BASE_BACKOFF, true, "")
+
+	response, _ := cache.Get("key")
+	AssertEqual(t, response.Gobj.Value, "found", "")
+}
@@ -0,0 +1,491 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	POOL_MAINTENANCE_INTERVAL = 5 * time.Second
+	HTTP_DIAL_TIMEOUT = 30 * time.Second
+)
+
+const (
+	NEGATIVE_POOL_SETTING_ERROR = "Pool settings must not be negative"
+	POOL_MIN_EXCEEDS_MAX_ERROR = "Pool MinConns must not exceed MaxConns"
+)
+
+// PoolConfig bounds the connections kept open to each node. A zero
+// MaxConns, IdleTimeout or MaxLifetime means no limit.
+type PoolConfig struct {
+	// MinConns connections are kept open even when idle. Only the TCP
+	// transport dials ahead of demand; over HTTP it only raises the number
+	// of idle connections kept for reuse.
+	MinConns int
+	// MaxConns caps the connections taking new requests.
+	MaxConns int
+	// IdleTimeout closes connections that have carried no request for
+	// this long.
+	IdleTimeout time.Duration
+	// MaxLifetime retires connections this old once they fall idle, so
+	// load spreads onto nodes behind a balancer or after a restart.
+	MaxLifetime time.Duration
+}
+
+func (this PoolConfig) validate() error {
+	if this.MinConns < 0 || this.MaxConns < 0 || this.IdleTimeout < 0 || this.MaxLifetime < 0 {
+		return errors.New(NEGATIVE_POOL_SETTING_ERROR)
+	}
+	if this.MaxConns > 0 && this.MinConns > this.MaxConns {
+		return errors.New(POOL_MIN_EXCEEDS_MAX_ERROR)
+	}
+	return nil
+}
+
+// DefaultPoolConfig matches the connection reuse of http.DefaultClient.
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{IdleTimeout: 90 * time.Second}
+}
+
+// PoolStats describes the connections to one node.
+type PoolStats struct {
+	Open       int
+	Idle       int
+	InFlight   int
+	Dials      uint64
+	DialErrors uint64
+	// Evicted counts connections dropped because they broke.
+	Evicted uint64
+	// Expired counts connections closed for being idle or too old.
+	Expired uint64
+}
+
+type poolStatsProvider interface {
+	PoolStats() map[string]PoolStats
+}
+
+// SetPoolConfig sets the connection pool kept to each node by the default
+// HTTP transport. Existing connections are closed as they fall idle.
+func (this *Cache) SetPoolConfig(config PoolConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	this.poolMu.Lock()
+	this.poolConfig = config
+	this.poolMu.Unlock()
+	if transport, ok := this.transport.(*httpTransport); ok {
+		transport.resetPools()
+	}
+	return nil
+}
+
+func (this *Cache) getPoolConfig() PoolConfig {
+	this.poolMu.Lock()
+	defer this.poolMu.Unlock()
+	return this.poolConfig
+}
+
+// PoolStats returns connection pool stats keyed by node, or nil if the
+// transport does not pool connections.
+func (this *Cache) PoolStats() map[string]PoolStats {
+	if provider, ok := this.transport.(poolStatsProvider); ok {
+		return provider.PoolStats()
+	}
+	return nil
+}
+
+// tcpPool holds the connections TCPTransport keeps open to one node.
+// Requests go to the least loaded connection, and a new connection is only
+// dialed when every open one is busy, so pipelining is preferred over
+// opening connections.
+type tcpPool struct {
+	addr      nodeAddress
+	transport *TCPTransport
+
+	mu      sync.Mutex
+	ready   *sync.Cond
+	config  PoolConfig
+	conns   []*tcpConn
+	dialing int
+	closed  bool
+	stats   PoolStats
+}
+
+func newTCPPool(transport *TCPTransport, addr nodeAddress, config PoolConfig) *tcpPool {
+	pool := &tcpPool{
+		addr:      addr,
+		transport: transport,
+		config:    config,
+	}
+	pool.ready = sync.NewCond(&pool.mu)
+	return pool
+}
+
+func (this *tcpPool) get() (*tcpConn, error) {
+	conn, dial, retired, err := this.pick()
+	closeConns(retired)
+	if !dial {
+		return conn, err
+	}
+	return this.dialConn(conn)
+}
+
+// pick chooses a connection for the next request or reserves a slot to
+// dial a new one, in which case the least loaded connection, if any, is
+// returned as a fallback for when the dial fails.
+func (this *tcpPool) pick() (*tcpConn, bool, []*tcpConn, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	var retired []*tcpConn
+	for {
+		if this.closed {
+			return nil, false, retired, errors.New(TRANSPORT_CLOSED_ERROR)
+		}
+		now := time.Now()
+		retired = append(retired, this.pruneLocked(now)...)
+
+		var best *tcpConn
+		live := this.dialing
+		for _, conn := range this.conns {
+			if this.tooOld(conn, now) {
+				continue
+			}
+			live++
+			if best == nil || conn.load() < best.load() {
+				best = conn
+			}
+		}
+		full := this.config.MaxConns > 0 && live >= this.config.MaxConns
+		if best != nil && (best.load() == 0 || full) {
+			return best, false, retired, nil
+		}
+		if !full {
+			this.dialing++
+			return best, true, retired, nil
+		}
+		// Every slot is taken by a dial in progress.
+		this.ready.Wait()
+	}
+}
+
+// dialConn opens a connection in a slot reserved by pick.
+func (this *tcpPool) dialConn(fallback *tcpConn) (*tcpConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), this.transport.timeout)
+	netConn, err := this.transport.dial(ctx, this.addr.network, this.addr.address)
+	cancel()
+
+	this.mu.Lock()
+	this.dialing--
+	this.ready.Broadcast()
+	if err != nil {
+		this.stats.DialErrors++
+		this.mu.Unlock()
+		if fallback != nil {
+			return fallback, nil
+		}
+		return nil, err
+	}
+	conn := newTCPConn(netConn, this.transport.encoding, this.evict)
+	if this.closed {
+		this.mu.Unlock()
+		conn.close(errors.New(TRANSPORT_CLOSED_ERROR))
+		return nil, errors.New(TRANSPORT_CLOSED_ERROR)
+	}
+	this.stats.Dials++
+	this.conns = append(this.conns, conn)
+	this.mu.Unlock()
+	return conn, nil
+}
+
+// evict drops a connection that broke. Only this connection is lost; the
+// node is still reachable through the rest of the pool.
+func (this *tcpPool) evict(conn *tcpConn) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for i, c := range this.conns {
+		if c == conn {
+			this.conns = append(this.conns[:i], this.conns[i+1:]...)
+			this.stats.Evicted++
+			return
+		}
+	}
+}
+
+func (this *tcpPool) tooOld(conn *tcpConn, now time.Time) bool {
+	return this.config.MaxLifetime > 0 && now.Sub(conn.created) >= this.config.MaxLifetime
+}
+
+// pruneLocked drops broken connections and removes idle ones that have
+// outlived the pool's limits, returning the latter for the caller to close
+// once the lock is released.
+func (this *tcpPool) pruneLocked(now time.Time) []*tcpConn {
+	var kept, retired []*tcpConn
+	open := len(this.conns)
+	for _, conn := range this.conns {
+		switch {
+		case !conn.healthy():
+			this.stats.Evicted++
+			open--
+		case conn.load() > 0:
+			kept = append(kept, conn)
+		case this.tooOld(conn, now),
+			this.config.IdleTimeout > 0 && now.Sub(conn.lastUsed()) >= this.config.IdleTimeout && open > this.config.MinConns:
+			this.stats.Expired++
+			retired = append(retired, conn)
+			open--
+		default:
+			kept = append(kept, conn)
+		}
+	}
+	this.conns = kept
+	return retired
+}
+
+// maintain reaps expired connections and dials up to MinConns.
+func (this *tcpPool) maintain() {
+	this.mu.Lock()
+	retired := this.pruneLocked(time.Now())
+	missing := 0
+	if !this.closed {
+		missing = this.config.MinConns - len(this.conns) - this.dialing
+	}
+	for i := 0; i < missing; i++ {
+		this.dialing++
+	}
+	this.mu.Unlock()
+
+	closeConns(retired)
+	for i := 0; i < missing; i++ {
+		this.dialConn(nil)
+	}
+}
+
+func (this *tcpPool) setConfig(config PoolConfig) {
+	this.mu.Lock()
+	this.config = config
+	this.ready.Broadcast()
+	this.mu.Unlock()
+}
+
+func (this *tcpPool) snapshot() PoolStats {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	stats := this.stats
+	for _, conn := range this.conns {
+		stats.Open++
+		if load := conn.load(); load > 0 {
+			stats.InFlight += load
+		} else {
+			stats.Idle++
+		}
+	}
+	return stats
+}
+
+func (this *tcpPool) close() {
+	this.mu.Lock()
+	this.closed = true
+	conns := this.conns
+	this.conns = nil
+	this.ready.Broadcast()
+	this.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.close(errors.New(TRANSPORT_CLOSED_ERROR))
+	}
+}
+
+func closeConns(conns []*tcpConn) {
+	for _, conn := range conns {
+		conn.close(errors.New(CONNECTION_CLOSED_ERROR))
+	}
+}
+
+// httpPool is the HTTP client used for one node. net/http does the pooling
+// itself; httpPool configures its limits and counts the connections it
+// dials so they can be reported and retired.
+type httpPool struct {
+	config    PoolConfig
+	addr      nodeAddress
+	dialer    DialContextFunc
+	transport *http.Transport
+	client    *http.Client
+
+	mu       sync.Mutex
+	conns    map[*pooledConn]struct{}
+	inFlight int
+	stats    PoolStats
+}
+
+func newHTTPPool(config PoolConfig, addr nodeAddress, dialer DialContextFunc) *httpPool {
+	if dialer == nil {
+		dialer = (&net.Dialer{KeepAlive: 30 * time.Second}).DialContext
+	}
+	pool := &httpPool{
+		config: config,
+		addr:   addr,
+		dialer: dialer,
+		conns:  make(map[*pooledConn]struct{}),
+	}
+	maxIdle := config.MaxConns
+	if maxIdle == 0 {
+		maxIdle = http.DefaultMaxIdleConnsPerHost
+	}
+	if maxIdle < config.MinConns {
+		maxIdle = config.MinConns
+	}
+	pool.transport = &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         pool.dial,
+		MaxConnsPerHost:     config.MaxConns,
+		MaxIdleConnsPerHost: maxIdle,
+		IdleConnTimeout:     config.IdleTimeout,
+	}
+	pool.client = &http.Client{Transport: pool.transport}
+	return pool
+}
+
+// dialError marks a failure to connect to a node, as opposed to a failure
+// on a connection that was already open.
+type dialError struct {
+	err error
+}
+
+func (this *dialError) Error() string {
+	return this.err.Error()
+}
+
+func (this *dialError) Unwrap() error {
+	return this.err
+}
+
+func (this *httpPool) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if this.addr.network == "unix" {
+		// The request URL only names a placeholder host.
+		network, address = this.addr.network, this.addr.address
+	}
+	ctx, cancel := context.WithTimeout(ctx, HTTP_DIAL_TIMEOUT)
+	defer cancel()
+	conn, err := this.dialer(ctx, network, address)
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if err != nil {
+		this.stats.DialErrors++
+		return nil, &dialError{err: err}
+	}
+	this.stats.Dials++
+	pooled := &pooledConn{Conn: conn, pool: this, created: time.Now()}
+	this.conns[pooled] = struct{}{}
+	return pooled, nil
+}
+
+func (this *httpPool) begin() {
+	this.mu.Lock()
+	this.inFlight++
+	this.mu.Unlock()
+}
+
+func (this *httpPool) end() {
+	this.mu.Lock()
+	this.inFlight--
+	this.mu.Unlock()
+}
+
+// broken records a request lost to a connection failing under it. net/http
+// has already discarded the connection.
+func (this *httpPool) broken() {
+	this.mu.Lock()
+	this.stats.Evicted++
+	this.mu.Unlock()
+}
+
+func (this *httpPool) release(conn *pooledConn) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	delete(this.conns, conn)
+	if this.config.MaxLifetime > 0 && time.Since(conn.created) >= this.config.MaxLifetime {
+		this.stats.Expired++
+	}
+}
+
+// maintain retires connections past MaxLifetime. net/http can only close
+// idle connections all at once, so this happens whenever any connection
+// is too old, and busy ones are caught on a later pass.
+func (this *httpPool) maintain() {
+	if this.config.MaxLifetime <= 0 {
+		return
+	}
+	this.mu.Lock()
+	expired := false
+	for conn := range this.conns {
+		if time.Since(conn.created) >= this.config.MaxLifetime {
+			expired = true
+			break
+		}
+	}
+	this.mu.Unlock()
+	if expired {
+		this.transport.CloseIdleConnections()
+	}
+}
+
+func (this *httpPool) snapshot() PoolStats {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	stats := this.stats
+	stats.Open = len(this.conns)
+	stats.InFlight = this.inFlight
+	if idle := stats.Open - stats.InFlight; idle > 0 {
+		stats.Idle = idle
+	}
+	return stats
+}
+
+// pooledConn reports its own closing back to the pool that dialed it.
+type pooledConn struct {
+	net.Conn
+	pool    *httpPool
+	created time.Time
+	once    sync.Once
+}
+
+func (this *pooledConn) Close() error {
+	this.once.Do(func() { this.pool.release(this) })
+	return this.Conn.Close()
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newPooledTCPCache(t *testing.T, config PoolConfig) (*Cache, *TCPTransport, *tcpStandIn) {
+	standIn := newTCPStandIn(t)
+	cache, _ := newTestCache(t)
+	transport := NewTCPTransport(standIn.port())
+	t.Cleanup(func() { transport.Close() })
+	if err := transport.SetPoolConfig(config); err != nil {
+		t.Fatal(err.Error())
+	}
+	cache.SetTransport(transport)
+	return cache, transport, standIn
+}
+
+func TestTCPPoolEvictsBrokenConnection(t *testing.T) {
+	cache, transport, standIn := newPooledTCPCache(t, PoolConfig{MaxConns: 1})
+	_, err := cache.Put("key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+
+	pool := transport.pools["127.0.0.1"]
+	pool.mu.Lock()
+	pool.conns[0].conn.Close()
+	pool.mu.Unlock()
+
+	value, _, err := cache.GetString("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "value", "")
+	AssertEqual(t, len(cache.getDeadServers()), 0, "")
+	AssertEqual(t, atomic.LoadInt32(&standIn.accepted), int32(2), "")
+
+	stats := cache.PoolStats()["127.0.0.1"]
+	AssertEqual(t, stats.Evicted, uint64(1), "")
+	AssertEqual(t, stats.Dials, uint64(2), "")
+	AssertEqual(t, stats.Open, 1, "")
+}
+
+func TestTCPPoolMaxConns(t *testing.T) {
+	cache, _, standIn := newPooledTCPCache(t, PoolConfig{MaxConns: 3})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := cache.Put(fmt.Sprintf("key:%d", i), i, NoExpiry)
+			AssertEqual(t, err, nil, "")
+		}(i)
+	}
+	wg.Wait()
+
+	accepted := atomic.LoadInt32(&standIn.accepted)
+	AssertEqual(t, accepted > 1 && accepted <= 3, true, "")
+	stats := cache.PoolStats()["127.0.0.1"]
+	AssertEqual(t, stats.Open, int(accepted), "")
+	AssertEqual(t, stats.Idle, stats.Open, "")
+	AssertEqual(t, stats.InFlight, 0, "")
+}
+
+func TestTCPPoolIdleTimeoutKeepsMinConns(t *testing.T) {
+	cache, transport, _ := newPooledTCPCache(t, PoolConfig{MinConns: 1, MaxConns: 4, IdleTimeout: 20 * time.Millisecond})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cache.Put(fmt.Sprintf("key:%d", i), i, NoExpiry)
+		}(i)
+	}
+	wg.Wait()
+	before := cache.PoolStats()["127.0.0.1"].Open
+	AssertEqual(t, before > 1, true, "")
+
+	time.Sleep(40 * time.Millisecond)
+	transport.pools["127.0.0.1"].maintain()
+
+	stats := cache.PoolStats()["127.0.0.1"]
+	AssertEqual(t, stats.Open, 1, "")
+	AssertEqual(t, stats.Expired, uint64(before-1), "")
+}
+
+func TestTCPPoolDialsMinConns(t *testing.T) {
+	_, transport, _ := newPooledTCPCache(t, PoolConfig{MinConns: 2, MaxConns: 4})
+
+	pool, _ := transport.getPool("127.0.0.1")
+	pool.maintain()
+
+	stats := transport.PoolStats()["127.0.0.1"]
+	AssertEqual(t, stats.Open, 2, "")
+	AssertEqual(t, stats.Dials, uint64(2), "")
+}
+
+func TestTCPPoolMaxLifetime(t *testing.T) {
+	cache, _, standIn := newPooledTCPCache(t, PoolConfig{MaxConns: 1, MaxLifetime: 20 * time.Millisecond})
+
+	_, err := cache.Put("key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	time.Sleep(30 * time.Millisecond)
+	_, err = cache.Put("key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+
+	AssertEqual(t, atomic.LoadInt32(&standIn.accepted), int32(2), "")
+	AssertEqual(t, cache.PoolStats()["127.0.0.1"].Expired, uint64(1), "")
+}
+
+func TestHTTPPoolStats(t *testing.T) {
+	cache, _ := newTestCache(t)
+	_, err := cache.Put("key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	_, err = cache.Get("key")
+	AssertEqual(t, err, nil, "")
+
+	stats := cache.PoolStats()["127.0.0.1"]
+	AssertEqual(t, stats.Dials, uint64(1), "")
+	AssertEqual(t, stats.Open, 1, "")
+	AssertEqual(t, stats.Idle, 1, "")
+}
+
+func TestHTTPPoolDoesNotResendNonIdempotentRequests(t *testing.T) {
+	cache, fake := newTestCache(t)
+	atomic.StoreInt32(&fake.drop, 1)
+
+	_, err := cache.Incr("counter", 1, NoExpiry)
+	AssertEqual(t, err != nil, true, "")
+	// A resend would have been answered, taking drop below zero.
+	AssertEqual(t, atomic.LoadInt32(&fake.drop), int32(0), "")
+}
+
+func TestReplacingHTTPTransportStopsMaintenance(t *testing.T) {
+	cache, _ := newTestCache(t)
+	previous := cache.transport.(*httpTransport)
+
+	transport := NewTCPTransport("0")
+	defer transport.Close()
+	cache.SetTransport(transport)
+	select {
+	case <-previous.done:
+	default:
+		t.Fatal("pool maintenance still running")
+	}
+}
+
+func TestHTTPPoolRetriesBrokenConnection(t *testing.T) {
+	cache, fake := newTestCache(t)
+	atomic.StoreInt32(&fake.drop, 1)
+
+	_, err := cache.Put("key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, len(cache.getDeadServers()), 0, "")
+	AssertEqual(t, cache.PoolStats()["127.0.0.1"].Evicted, uint64(1), "")
+}
+
+func TestSetPoolConfigValidates(t *testing.T) {
+	cache, _ := newTestCache(t)
+	AssertEqual(t, cache.SetPoolConfig(PoolConfig{MaxConns: -1}) != nil, true, "")
+	AssertEqual(t, cache.SetPoolConfig(PoolConfig{MinConns: 3, MaxConns: 2}) != nil, true, "")
+	AssertEqual(t, cache.SetPoolConfig(PoolConfig{MinConns: 3}), nil, "")
+}
@@ -35,21 +35,32 @@ import (
 	"hash/crc32"
 	"log"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 const (
 	EMPTY_CONFIG_ERR = "Cluster configuration file is empty!"
 )
 
+// NODE_ID_OPTION may follow a node's address in the cluster config, as in
+// "localhost:7992 id=cache-2", to fix the ID the node is hashed under.
+// Nodes that share a host need one so that they hash apart, and it keeps
+// their keys in place if the address changes.
+const NODE_ID_OPTION = "id="
+
 type Ring struct {
 	replicas int
 	ring     *AVLTree
+	ids      map[string]string
+	mu       sync.RWMutex
 }
 
 func NewRing(clusterConfig string, replicas int) *Ring {
 	var ring *Ring = &Ring{
 		replicas: replicas,
 		ring: NewAvlTree(),
+		ids: make(map[string]string),
 	}
 	if clusterConfig != "" {
 		ring.initRing(clusterConfig)
@@ -58,22 +69,30 @@ func NewRing(clusterConfig string, replicas int) *Ring {
 }
 
 func (this *Ring) Add(node string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	var id string = this.idOf(node)
 	for i := 0; i < this.replicas; i++ {
-		var index string = keyHash(node, i)
+		var index string = keyHash(id, i)
 		var vp *VirtualPoint = NewVirtualPoint(node, index)
 		this.ring.InsertNode(index, vp)
 	}
 }
 
 func (this *Ring) Delete(node string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
 	var index string
+	var id string = this.idOf(node)
 	for i := 0; i < this.replicas; i++ {
-		index = keyHash(node, i)
+		index = keyHash(id, i)
 		this.ring.RemoveNode(index)
 	}
 }
 
 func (this *Ring) GetPoint(key string) *Pair {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
 	var ringSize int = len(this.ring.InOrderTraverse())
 	if ringSize == 0 {
 		return nil
@@ -87,6 +106,8 @@ func (this *Ring) GetPoint(key string) *Pair {
 }
 
 func (this *Ring) GetPoints() []*VirtualPoint {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
 	return this.ring.GetNodes()
 }
 
@@ -96,13 +117,43 @@ func (this *Ring) initRing(clusterConfig string) {
 		log.Fatalf("Failed to read from cluster configuration: %s", err.Error())
 	}
 
-	if len(nodes) != 0 {
-		for _, node := range nodes {
-			this.Add(node)
+	var entries []string
+	owners := make(map[string]string)
+	for _, line := range nodes {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
 		}
-	} else {
+		node, id := fields[0], nodeID(fields[0])
+		for _, option := range fields[1:] {
+			if !strings.HasPrefix(option, NODE_ID_OPTION) || len(option) == len(NODE_ID_OPTION) {
+				log.Fatalf("Unrecognised option %q for node %s in cluster configuration", option, node)
+			}
+			id = strings.TrimPrefix(option, NODE_ID_OPTION)
+			this.ids[node] = id
+		}
+		if owner, ok := owners[id]; ok {
+			log.Fatalf("Nodes %s and %s in cluster configuration share the ID %q; give one of them an id= option", owner, node, id)
+		}
+		owners[id] = node
+		entries = append(entries, node)
+	}
+	if len(entries) == 0 {
 		log.Fatal(EMPTY_CONFIG_ERR)
 	}
+
+	for _, node := range entries {
+		this.Add(node)
+	}
+}
+
+// idOf returns the ID node is hashed under: the id= option from its config
+// entry if it has one, otherwise its host. The lock must be held.
+func (this *Ring) idOf(node string) string {
+	if id, ok := this.ids[node]; ok {
+		return id
+	}
+	return nodeID(node)
 }
 
 func keyHash(key string, index ...int) string {
@@ -84,4 +84,49 @@ func TestRingInitFromConfig(t *testing.T) {
 	nodes := ring.GetPoints()
 	AssertEqual(t, nodes[0].index, "95412376", "")
 	AssertEqual(t, nodes[1].index, "af102aa1", "")
-}
\ No newline at end of file
+}
+
+func TestRingHashesNodesByHost(t *testing.T) {
+	ring := NewRing(writeClusterConfig(t, "10.23.20.2:7991", "[::1]:7991", "https://10.23.34.4:8443"), 1)
+	bare := NewRing(writeClusterConfig(t, "10.23.20.2", "::1", "10.23.34.4"), 1)
+	moved := NewRing(writeClusterConfig(t, "10.23.20.2:7992", "tcp://[::1]:7000", "10.23.34.4:9443"), 1)
+
+	nodes, bareNodes, movedNodes := ring.GetPoints(), bare.GetPoints(), moved.GetPoints()
+	AssertEqual(t, len(nodes), 3, "")
+	for i := range nodes {
+		// Neither adding a port nor changing it moves a node's keys.
+		AssertEqual(t, nodes[i].index, bareNodes[i].index, "")
+		AssertEqual(t, nodes[i].index, movedNodes[i].index, "")
+	}
+}
+
+func TestRingExplicitNodeIDs(t *testing.T) {
+	alone := NewRing(writeClusterConfig(t, "localhost:7991 id=cache-1"), 1)
+	shared := NewRing(writeClusterConfig(t, "localhost:7991 id=cache-1", "localhost:7992  id=cache-2"), 1)
+	moved := NewRing(writeClusterConfig(t, "localhost:8991 id=cache-1"), 1)
+	AssertEqual(t, len(shared.GetPoints()), 2, "")
+
+	// A node keeps its points when another joins on the same host and when
+	// its own address changes.
+	index := alone.GetPoints()[0].index
+	AssertEqual(t, index, keyHash("cache-1", 0), "")
+	AssertEqual(t, moved.GetPoints()[0].index, index, "")
+	found := false
+	for _, vp := range shared.GetPoints() {
+		if vp.ip == "localhost:7991" {
+			found = vp.index == index
+		}
+	}
+	AssertEqual(t, found, true, "")
+
+	// Revived nodes come back under their configured ID.
+	shared.Delete("localhost:7992")
+	AssertEqual(t, len(shared.GetPoints()), 1, "")
+	shared.Add("localhost:7992")
+	AssertEqual(t, len(shared.GetPoints()), 2, "")
+	for _, vp := range shared.GetPoints() {
+		if vp.ip == "localhost:7992" {
+			AssertEqual(t, vp.index, keyHash("cache-2", 0), "")
+		}
+	}
+}
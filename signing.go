@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"sort"
+)
+
+// SetSigningKey signs every value written by this client with
+// HMAC-SHA256 over the value, its metadata and the key it is written to.
+// Reads then reject, with a *TamperError, values that are unsigned, were
+// signed with a different secret, or were written for a different key.
+// Passing nil disables signing.
+//
+// Counters maintained with Incr and Decr are updated by the server and
+// can't be signed, so read them with Incr(key, 0, ttl) rather than Get.
+func (this *Cache) SetSigningKey(secret []byte) {
+	this.signingKey = secret
+}
+
+// sign returns a copy of env carrying its signature for key.
+func (this *Cache) sign(key string, env *valueEnvelope) *valueEnvelope {
+	if this.signingKey == nil {
+		return env
+	}
+	signed := *env
+	signed.Signature = this.signature(key, env)
+	return &signed
+}
+
+func (this *Cache) verifySignature(key string, env *valueEnvelope) error {
+	if len(env.Signature) == 0 {
+		return &TamperError{Key: key, Reason: "value is not signed"}
+	}
+	if !hmac.Equal(env.Signature, this.signature(key, env)) {
+		return &TamperError{Key: key, Reason: "signature mismatch"}
+	}
+	return nil
+}
+
+// signature computes the MAC over everything in env that affects what a
+// read returns. Fields are length-prefixed so that no two envelopes share
+// the same input.
+func (this *Cache) signature(key string, env *valueEnvelope) []byte {
+	mac := hmac.New(sha256.New, this.signingKey)
+	writeField(mac, []byte(key))
+	writeField(mac, []byte(env.Codec))
+	writeField(mac, []byte(env.Compressor))
+	writeField(mac, []byte(env.KeyID))
+	writeField(mac, env.Nonce)
+	writeField(mac, env.Payload)
+
+	var absent int64
+	if env.Absent {
+		absent = 1
+	}
+	for _, n := range []int64{int64(env.Marker), env.FreshUntil, env.Expiry, env.Delta, absent} {
+		binary.Write(mac, binary.BigEndian, n)
+	}
+
+	tags := make([]string, 0, len(env.Tags))
+	for tag := range env.Tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		writeField(mac, []byte(tag))
+		binary.Write(mac, binary.BigEndian, env.Tags[tag])
+	}
+	return mac.Sum(nil)
+}
+
+func writeField(mac hash.Hash, field []byte) {
+	binary.Write(mac, binary.BigEndian, uint32(len(field)))
+	mac.Write(field)
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestSignedRoundTrip(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.SetSigningKey([]byte("tenant-7-secret"))
+
+	cache.Put("Ireland", "Dublin", NoExpiry)
+	response, err := cache.Get("Ireland")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, response.Gobj.Value, "Dublin", "")
+}
+
+func TestSigningRejectsForeignValues(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.Put("unsigned", "Dublin", NoExpiry)
+
+	cache.SetSigningKey([]byte("tenant-7-secret"))
+	cache.Put("Ireland", "Dublin", NoExpiry)
+
+	// Written without the secret.
+	_, err := cache.Get("unsigned")
+	_, isTamper := err.(*TamperError)
+	AssertEqual(t, isTamper, true, "")
+
+	// Written for a different key.
+	fake.objects["France"] = fake.objects["Ireland"]
+	_, err = cache.Get("France")
+	_, isTamper = err.(*TamperError)
+	AssertEqual(t, isTamper, true, "")
+
+	// Written by a client with a different secret.
+	cache.SetSigningKey([]byte("tenant-8-secret"))
+	_, err = cache.Get("Ireland")
+	_, isTamper = err.(*TamperError)
+	AssertEqual(t, isTamper, true, "")
+}
+
+func TestSignatureCoversMetadata(t *testing.T) {
+	cache := &Cache{}
+	cache.SetSigningKey([]byte("secret"))
+
+	env := newValueEnvelope(nil)
+	env.Codec = "json"
+	env.Payload = []byte(`"Dublin"`)
+	env.Tags = map[string]int64{"product:42": 1}
+	signed := cache.sign("Ireland", env)
+	AssertEqual(t, cache.verifySignature("Ireland", signed), nil, "")
+
+	signed.Tags["product:42"] = 2
+	AssertEqual(t, cache.verifySignature("Ireland", signed) != nil, true, "")
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup deduplicates concurrent calls for the same key so that only
+// one of them does the work and the rest wait for its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done      chan struct{}
+	value     interface{}
+	err       error
+	recovered interface{}
+	dups      int
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		calls: make(map[string]*flightCall),
+	}
+}
+
+// Do runs fn once for all concurrent callers sharing key. shared reports
+// whether the result was handed to more than one caller.
+func (this *flightGroup) Do(key string, fn func() (interface{}, error)) (value interface{}, err error, shared bool) {
+	return this.DoContext(context.Background(), key, fn)
+}
+
+// DoContext is like Do, but fn runs on its own goroutine and each caller
+// stops waiting when its own ctx is done, leaving fn to finish for the
+// others. If fn panics, the panic is re-raised in every caller waiting for
+// it and later calls for key start afresh.
+func (this *flightGroup) DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (value interface{}, err error, shared bool) {
+	this.mu.Lock()
+	call, joined := this.calls[key]
+	if joined {
+		call.dups++
+	} else {
+		call = &flightCall{done: make(chan struct{})}
+		this.calls[key] = call
+		go this.run(key, call, fn)
+	}
+	this.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err(), joined
+	}
+	if call.recovered != nil {
+		panic(call.recovered)
+	}
+	this.mu.Lock()
+	shared = call.dups > 0
+	this.mu.Unlock()
+	return call.value, call.err, shared
+}
+
+func (this *flightGroup) run(key string, call *flightCall, fn func() (interface{}, error)) {
+	defer func() {
+		call.recovered = recover()
+		this.mu.Lock()
+		delete(this.calls, key)
+		this.mu.Unlock()
+		close(call.done)
+	}()
+	call.value, call.err = fn()
+}
+
+// InFlight reports whether a call for key is currently running.
+func (this *flightGroup) InFlight(key string) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	_, ok := this.calls[key]
+	return ok
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupDeduplicatesCalls(t *testing.T) {
+	group := newFlightGroup()
+	var calls int32
+	var wg sync.WaitGroup
+	release := make(chan struct{})
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err, _ := group.Do("key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "loaded", nil
+			})
+			AssertEqual(t, err, nil, "")
+			AssertEqual(t, value, "loaded", "")
+		}()
+	}
+
+	for !group.InFlight("key") {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	AssertEqual(t, atomic.LoadInt32(&calls), int32(1), "")
+	AssertEqual(t, group.InFlight("key"), false, "")
+}
+
+func TestFlightGroupRecoversFromPanic(t *testing.T) {
+	group := newFlightGroup()
+	func() {
+		defer func() {
+			AssertEqual(t, recover(), "boom", "")
+		}()
+		group.Do("key", func() (interface{}, error) {
+			panic("boom")
+		})
+	}()
+	AssertEqual(t, group.InFlight("key"), false, "")
+
+	value, err, _ := group.Do("key", func() (interface{}, error) {
+		return "loaded", nil
+	})
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "loaded", "")
+}
+
+func TestFlightGroupCallerCancellation(t *testing.T) {
+	group := newFlightGroup()
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		return "loaded", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error)
+	go func() {
+		_, err, _ := group.DoContext(ctx, "key", fn)
+		leader <- err
+	}()
+	for !group.InFlight("key") {
+		time.Sleep(time.Millisecond)
+	}
+	follower := make(chan interface{})
+	go func() {
+		value, _, _ := group.DoContext(context.Background(), "key", fn)
+		follower <- value
+	}()
+
+	cancel()
+	AssertEqual(t, <-leader, context.Canceled, "")
+	close(release)
+	AssertEqual(t, <-follower, "loaded", "")
+}
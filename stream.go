@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	CACHE_MISS_ERROR = "Key is not in the cache"
+	STREAM_CLOSED_ERROR = "Stream is closed"
+)
+
+// ErrCacheMiss is returned by NewReader when there is no value at the key.
+var ErrCacheMiss = errors.New(CACHE_MISS_ERROR)
+
+// cacheWriter streams a value into the cache one chunk at a time using the
+// same layout as PutLarge.
+type cacheWriter struct {
+	large  *largeWriter
+	buf    []byte
+	size   int
+	err    error
+	closed bool
+}
+
+// NewWriter returns a writer that stores everything written to it under
+// key, holding at most one chunk in memory. The value becomes visible when
+// Close returns without error. If a write fails, the chunks written so far
+// are removed and every later call returns the error.
+func (this *Cache) NewWriter(key string, ttl time.Duration) (io.WriteCloser, error) {
+	large, err := this.newLargeWriter(key, RawCodec{}.Name(), ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheWriter{
+		large: large,
+		buf:   make([]byte, 0, this.chunkSize),
+		size:  this.chunkSize,
+	}, nil
+}
+
+func (this *cacheWriter) Write(p []byte) (int, error) {
+	if this.err != nil {
+		return 0, this.err
+	}
+	if this.closed {
+		return 0, errors.New(STREAM_CLOSED_ERROR)
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := this.size - len(this.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		this.buf = append(this.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(this.buf) == this.size {
+			if err := this.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (this *cacheWriter) Close() error {
+	if this.err != nil || this.closed {
+		return this.err
+	}
+	this.closed = true
+	if len(this.buf) > 0 {
+		if err := this.flush(); err != nil {
+			return err
+		}
+	}
+	if err := this.large.commit(); err != nil {
+		this.fail(err)
+		return err
+	}
+	return nil
+}
+
+func (this *cacheWriter) flush() error {
+	if err := this.large.writeChunk(this.buf); err != nil {
+		this.fail(err)
+		return err
+	}
+	this.buf = this.buf[:0]
+	return nil
+}
+
+func (this *cacheWriter) fail(err error) {
+	this.err = err
+	this.large.abort()
+}
+
+// cacheReader streams a chunked value out of the cache, fetching and
+// verifying one chunk at a time.
+type cacheReader struct {
+	cache    *Cache
+	key      string
+	manifest *largeManifest
+	next     int
+	chunk    []byte
+	closed   bool
+}
+
+// NewReader returns a reader over a value written with NewWriter or
+// PutLarge. It returns ErrCacheMiss if there is no value at key. If a
+// chunk has expired mid-stream, Read returns io.ErrUnexpectedEOF.
+func (this *Cache) NewReader(key string) (io.ReadCloser, error) {
+	manifest, found, err := this.getManifest(key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrCacheMiss
+	}
+	return &cacheReader{
+		cache:    this,
+		key:      key,
+		manifest: manifest,
+	}, nil
+}
+
+func (this *cacheReader) Read(p []byte) (int, error) {
+	if this.closed {
+		return 0, errors.New(STREAM_CLOSED_ERROR)
+	}
+	for len(this.chunk) == 0 {
+		if this.next == len(this.manifest.Checksums) {
+			return 0, io.EOF
+		}
+		chunk, found, err := this.cache.getChunk(this.key, this.manifest, this.next)
+		if err != nil {
+			return 0, err
+		}
+		if !found {
+			return 0, io.ErrUnexpectedEOF
+		}
+		this.chunk = chunk
+		this.next++
+	}
+	n := copy(p, this.chunk)
+	this.chunk = this.chunk[n:]
+	return n, nil
+}
+
+func (this *cacheReader) Close() error {
+	this.closed = true
+	this.chunk = nil
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetChunkSize(10)
+	data := []byte(strings.Repeat("0123456789abcdef", 7))
+
+	writer, err := cache.NewWriter("upload", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	n, err := io.Copy(writer, bytes.NewReader(data))
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, n, int64(len(data)), "")
+
+	// Nothing is visible until the writer is closed.
+	_, err = cache.NewReader("upload")
+	AssertEqual(t, err, ErrCacheMiss, "")
+
+	AssertEqual(t, writer.Close(), nil, "")
+	AssertEqual(t, len(fake.objects), 1+(len(data)+9)/10, "")
+
+	reader, err := cache.NewReader("upload")
+	AssertEqual(t, err, nil, "")
+	read, err := ioutil.ReadAll(reader)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, string(read), string(data), "")
+	AssertEqual(t, reader.Close(), nil, "")
+
+	// Streamed values are raw bytes to GetLarge too.
+	var whole []byte
+	found, err := cache.GetLargeInto("upload", &whole)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, found, true, "")
+	AssertEqual(t, string(whole), string(data), "")
+}
+
+func TestStreamReaderMissingChunk(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetChunkSize(4)
+
+	writer, _ := cache.NewWriter("upload", NoExpiry)
+	writer.Write([]byte("0123456789"))
+	writer.Close()
+
+	manifest, _, _ := cache.getManifest("upload")
+	delete(fake.objects, chunkKey("upload", manifest.ID, 1))
+
+	reader, _ := cache.NewReader("upload")
+	_, err := ioutil.ReadAll(reader)
+	AssertEqual(t, err, io.ErrUnexpectedEOF, "")
+}
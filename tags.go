@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	TAG_VERSION_PREFIX = "__ghostdb:tag:"
+)
+
+const (
+	EMPTY_TAG_ERROR = "Tags must not be empty"
+	CORRUPT_TAG_VERSION_ERROR = "Tag version counter is not numeric"
+	INVALID_TAG_CACHE_SIZE_ERROR = "Tag version cache size must be at least 1"
+	INVALID_TAG_CACHE_TTL_ERROR = "Tag version cache TTL must be positive"
+)
+
+// PutTagged stores value like Put and associates it with tags, so that it
+// can later be dropped with InvalidateTag.
+func (this *Cache) PutTagged(key string, value interface{}, ttl time.Duration, tags ...string) (CacheResponse, error) {
+	env, err := this.tagEnvelope(context.Background(), value, tags)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	return this.Put(key, env, ttl)
+}
+
+// AddTagged stores value like Add and associates it with tags.
+func (this *Cache) AddTagged(key string, value interface{}, ttl time.Duration, tags ...string) (CacheResponse, error) {
+	env, err := this.tagEnvelope(context.Background(), value, tags)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	return this.Add(key, env, ttl)
+}
+
+// InvalidateTag drops every value stored with tag. Tagged values record
+// the version of each of their tags when written, and reads treat a value
+// as a miss once any of those versions has moved on. Invalidated values
+// stay in the cluster until their TTLs lapse.
+func (this *Cache) InvalidateTag(tag string) error {
+	if tag == "" {
+		return errors.New(EMPTY_TAG_ERROR)
+	}
+	_, err := this.Incr(tagVersionKey(tag), 1, NoExpiry)
+	if err != nil {
+		return err
+	}
+	if this.tagVersionCache != nil {
+		this.tagVersionCache.Delete(tagVersionKey(tag))
+	}
+	// The near-cache doesn't know which of its entries carry the tag.
+	if this.nearCache != nil {
+		this.nearCache.Clear()
+	}
+	return nil
+}
+
+// EnableTagVersionCache keeps the versions of up to maxEntries tags in
+// process for at most ttl, so reading a tagged value does not cost a round
+// trip per tag. Invalidations made through this Cache are seen at once,
+// but those made by other clients only once the cached version expires.
+func (this *Cache) EnableTagVersionCache(maxEntries int, ttl time.Duration) error {
+	if maxEntries < 1 {
+		return errors.New(INVALID_TAG_CACHE_SIZE_ERROR)
+	}
+	if ttl <= 0 {
+		return errors.New(INVALID_TAG_CACHE_TTL_ERROR)
+	}
+	this.tagVersionCache = newNearCache(maxEntries, ttl)
+	return nil
+}
+
+func (this *Cache) DisableTagVersionCache() {
+	this.tagVersionCache = nil
+}
+
+func (this *Cache) tagEnvelope(ctx context.Context, value interface{}, tags []string) (*valueEnvelope, error) {
+	for _, tag := range tags {
+		if tag == "" {
+			return nil, errors.New(EMPTY_TAG_ERROR)
+		}
+	}
+	// Writes always record the current versions, or a value could be
+	// written already invalidated.
+	versions, err := this.tagVersions(ctx, tags, false)
+	if err != nil {
+		return nil, err
+	}
+	env := newValueEnvelope(value)
+	env.Tags = versions
+	return env, nil
+}
+
+// tagsValid reports whether every tag is still at the version recorded
+// when the value was written.
+func (this *Cache) tagsValid(ctx context.Context, tags map[string]int64) (bool, error) {
+	names := make([]string, 0, len(tags))
+	for tag := range tags {
+		names = append(names, tag)
+	}
+	current, err := this.tagVersions(ctx, names, true)
+	if err != nil {
+		return false, err
+	}
+	for tag, version := range tags {
+		if current[tag] != version {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// tagVersions looks up the versions of tags, in parallel when there are
+// several, so a value's tags cost one round trip between them.
+func (this *Cache) tagVersions(ctx context.Context, tags []string, useLocal bool) (map[string]int64, error) {
+	unique := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		unique[tag] = true
+	}
+
+	versions := make(map[string]int64, len(unique))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	for tag := range unique {
+		wg.Add(1)
+		go func(tag string) {
+			defer wg.Done()
+			version, err := this.tagVersion(ctx, tag, useLocal)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			versions[tag] = version
+		}(tag)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return versions, nil
+}
+
+func (this *Cache) tagVersion(ctx context.Context, tag string, useLocal bool) (int64, error) {
+	key := tagVersionKey(tag)
+	cache := this.tagVersionCache
+	if useLocal && cache != nil {
+		if response, _, ok := cache.Get(key); ok {
+			return response.Gobj.Value.(int64), nil
+		}
+	}
+
+	response, err := this.getRemote(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	if response.Message != CACHE_MISS {
+		var ok bool
+		if version, ok = toInt64(response.Gobj.Value); !ok {
+			return 0, errors.New(CORRUPT_TAG_VERSION_ERROR)
+		}
+	}
+	if cache != nil {
+		cache.Set(key, CacheResponse{Gobj: GhostObject{Key: key, Value: version}}, nil)
+	}
+	return version, nil
+}
+
+func tagVersionKey(tag string) string {
+	return TAG_VERSION_PREFIX + tag
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInvalidateTag(t *testing.T) {
+	cache, _ := newTestCache(t)
+
+	_, err := cache.PutTagged("product:42:page", "<html>", NoExpiry, "product:42", "tenant:7")
+	AssertEqual(t, err, nil, "")
+	_, err = cache.PutTagged("product:43:page", "<html>", NoExpiry, "product:43", "tenant:7")
+	AssertEqual(t, err, nil, "")
+
+	response, err := cache.Get("product:42:page")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, response.Gobj.Value, "<html>", "")
+
+	err = cache.InvalidateTag("product:42")
+	AssertEqual(t, err, nil, "")
+
+	response, _ = cache.Get("product:42:page")
+	AssertEqual(t, response.Message, CACHE_MISS, "")
+	response, _ = cache.Get("product:43:page")
+	AssertEqual(t, response.Gobj.Value, "<html>", "")
+
+	cache.InvalidateTag("tenant:7")
+	response, _ = cache.Get("product:43:page")
+	AssertEqual(t, response.Message, CACHE_MISS, "")
+}
+
+func TestTagVersionCache(t *testing.T) {
+	cache, fake := newTestCache(t)
+	AssertEqual(t, cache.EnableTagVersionCache(100, time.Minute), nil, "")
+	cache.PutTagged("page", "<html>", NoExpiry, "product:42", "tenant:7")
+
+	for i := 0; i < 3; i++ {
+		response, err := cache.Get("page")
+		AssertEqual(t, err, nil, "")
+		AssertEqual(t, response.Gobj.Value, "<html>", "")
+	}
+	fake.mu.Lock()
+	AssertEqual(t, fake.reads[tagVersionKey("product:42")], 1, "")
+	fake.mu.Unlock()
+
+	// Invalidations through this client are seen despite the cache.
+	cache.InvalidateTag("product:42")
+	response, _ := cache.Get("page")
+	AssertEqual(t, response.Message, CACHE_MISS, "")
+
+	AssertEqual(t, cache.EnableTagVersionCache(0, time.Minute) != nil, true, "")
+	AssertEqual(t, cache.EnableTagVersionCache(1, 0) != nil, true, "")
+}
@@ -0,0 +1,466 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	TCP_MAX_FRAME_SIZE = 64 << 20
+	DEFAULT_TCP_TIMEOUT = 5 * time.Second
+	TCP_BROKEN_CONN_RETRIES = 1
+)
+
+const (
+	FRAME_TOO_LARGE_ERROR = "Frame exceeds the maximum frame size"
+	CONNECTION_CLOSED_ERROR = "Connection closed"
+	TRANSPORT_CLOSED_ERROR = "Transport closed"
+)
+
+// tcpFrame is the unit of the TCP protocol. Each frame is sent as a four
+// byte big-endian length followed by the frame in the transport's wire
+// encoding. Responses carry the ID of the request they answer, so many
+// requests can be in flight on one connection and answered in any order.
+type tcpFrame struct {
+	ID       uint64         `json:"id"`
+	Op       string         `json:"op,omitempty"`
+	Request  *CacheRequest  `json:"req,omitempty"`
+	Metadata Metadata       `json:"md,omitempty"`
+	Response *CacheResponse `json:"res,omitempty"`
+	Error    string         `json:"err,omitempty"`
+}
+
+// TCPTransport speaks a length-prefixed framed protocol over long-lived
+// TCP connections, pipelining concurrent requests to the same node instead
+// of paying for an HTTP exchange per request. By default it keeps a single
+// connection per node; SetPoolConfig allows more.
+type TCPTransport struct {
+	port     string
+	timeout  time.Duration
+	encoding WireEncoding
+	dialer   DialContextFunc
+	nextID   uint64
+
+	mu     sync.Mutex
+	config PoolConfig
+	pools  map[string]*tcpPool
+	closed bool
+	done   chan struct{}
+}
+
+func NewTCPTransport(port string) *TCPTransport {
+	transport := &TCPTransport{
+		port:     port,
+		timeout:  DEFAULT_TCP_TIMEOUT,
+		encoding: JSONWireEncoding{},
+		config:   PoolConfig{MaxConns: 1},
+		pools:    make(map[string]*tcpPool),
+		done:     make(chan struct{}),
+	}
+	go transport.maintainPools()
+	return transport
+}
+
+// SetTimeout bounds how long a request waits for its response.
+func (this *TCPTransport) SetTimeout(timeout time.Duration) {
+	this.timeout = timeout
+}
+
+// SetWireEncoding sets the encoding of frames. Both ends must agree on it.
+func (this *TCPTransport) SetWireEncoding(encoding WireEncoding) {
+	this.encoding = encoding
+}
+
+// SetDialContext sets the function used to open connections. Pass nil to
+// use net.Dialer.
+func (this *TCPTransport) SetDialContext(dial DialContextFunc) {
+	this.dialer = dial
+}
+
+func (this *TCPTransport) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if this.dialer != nil {
+		return this.dialer(ctx, network, address)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
+
+// SetPoolConfig sets the connection pool kept to each node.
+func (this *TCPTransport) SetPoolConfig(config PoolConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	this.mu.Lock()
+	this.config = config
+	pools := this.poolList()
+	this.mu.Unlock()
+
+	for _, pool := range pools {
+		pool.setConfig(config)
+	}
+	return nil
+}
+
+// PoolStats returns connection pool stats keyed by node.
+func (this *TCPTransport) PoolStats() map[string]PoolStats {
+	this.mu.Lock()
+	pools := make(map[string]*tcpPool, len(this.pools))
+	for node, pool := range this.pools {
+		pools[node] = pool
+	}
+	this.mu.Unlock()
+
+	stats := make(map[string]PoolStats, len(pools))
+	for node, pool := range pools {
+		stats[node] = pool.snapshot()
+	}
+	return stats
+}
+
+func (this *TCPTransport) RoundTrip(ctx context.Context, node string, requestType string, request CacheRequest) (CacheResponse, error) {
+	pool, err := this.getPool(node)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	frame := tcpFrame{
+		ID:      atomic.AddUint64(&this.nextID, 1),
+		Op:       requestType,
+		Request:  &request,
+		Metadata: MetadataFromContext(ctx),
+	}
+	for attempt := 0; ; attempt++ {
+		conn, err := pool.get()
+		if err != nil {
+			return CacheResponse{}, err
+		}
+		response, err := conn.roundTrip(ctx, frame, this.timeout)
+		var unsent *unsentError
+		resend := errors.As(err, &unsent) || idempotentRequests[requestType]
+		if err != nil && !conn.healthy() && resend && attempt < TCP_BROKEN_CONN_RETRIES {
+			// The connection broke rather than the node failing the
+			// request, so try a fresh one before the node is blamed.
+			// Requests the node may have applied are only resent if
+			// they are idempotent.
+			continue
+		}
+		var requestErr *RequestError
+		if errors.As(err, &requestErr) {
+			requestErr.Node = node
+		}
+		return response, err
+	}
+}
+
+// Close closes every connection, failing any requests still in flight.
+func (this *TCPTransport) Close() error {
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		return nil
+	}
+	this.closed = true
+	pools := this.poolList()
+	this.pools = make(map[string]*tcpPool)
+	close(this.done)
+	this.mu.Unlock()
+
+	for _, pool := range pools {
+		pool.close()
+	}
+	return nil
+}
+
+func (this *TCPTransport) getPool(node string) (*tcpPool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.closed {
+		return nil, errors.New(TRANSPORT_CLOSED_ERROR)
+	}
+	pool, ok := this.pools[node]
+	if !ok {
+		addr, err := parseNodeAddress(node, TCP_SCHEME, this.port)
+		if err != nil {
+			return nil, err
+		}
+		if addr.scheme == HTTPS_SCHEME {
+			return nil, fmt.Errorf("node %q requires TLS, which TCPTransport does not support", node)
+		}
+		pool = newTCPPool(this, addr, this.config)
+		this.pools[node] = pool
+	}
+	return pool, nil
+}
+
+func (this *TCPTransport) poolList() []*tcpPool {
+	pools := make([]*tcpPool, 0, len(this.pools))
+	for _, pool := range this.pools {
+		pools = append(pools, pool)
+	}
+	return pools
+}
+
+func (this *TCPTransport) maintainPools() {
+	ticker := time.NewTicker(POOL_MAINTENANCE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.done:
+			return
+		case <-ticker.C:
+			this.mu.Lock()
+			pools := this.poolList()
+			this.mu.Unlock()
+			for _, pool := range pools {
+				pool.maintain()
+			}
+		}
+	}
+}
+
+// unsentError is returned for a request that was never written because
+// its connection had already failed, so it is always safe to resend.
+type unsentError struct {
+	err error
+}
+
+func (this *unsentError) Error() string {
+	return this.err.Error()
+}
+
+func (this *unsentError) Unwrap() error {
+	return this.err
+}
+
+type tcpResult struct {
+	response CacheResponse
+	err      error
+}
+
+// tcpConn multiplexes requests over one connection. Writes are serialized
+// and a single goroutine reads responses and hands them to their callers.
+type tcpConn struct {
+	used     int64
+	inFlight int32
+
+	conn     net.Conn
+	encoding WireEncoding
+	onClose  func(*tcpConn)
+	created  time.Time
+
+	writeMu sync.Mutex
+	writer  *bufio.Writer
+
+	mu      sync.Mutex
+	pending map[uint64]chan tcpResult
+	err     error
+}
+
+func newTCPConn(conn net.Conn, encoding WireEncoding, onClose func(*tcpConn)) *tcpConn {
+	c := &tcpConn{
+		conn:     conn,
+		encoding: encoding,
+		onClose:  onClose,
+		created:  time.Now(),
+		used:     time.Now().UnixNano(),
+		writer:   bufio.NewWriter(conn),
+		pending:  make(map[uint64]chan tcpResult),
+	}
+	go c.readLoop()
+	return c
+}
+
+func (this *tcpConn) roundTrip(ctx context.Context, frame tcpFrame, timeout time.Duration) (CacheResponse, error) {
+	atomic.AddInt32(&this.inFlight, 1)
+	defer func() {
+		atomic.StoreInt64(&this.used, time.Now().UnixNano())
+		atomic.AddInt32(&this.inFlight, -1)
+	}()
+
+	// A request that can't be framed is the caller's problem, so it fails
+	// alone without disturbing the other requests on the connection.
+	payload, err := this.encoding.Marshal(frame)
+	if err != nil {
+		return CacheResponse{}, &RequestError{Err: err}
+	}
+	if len(payload) > TCP_MAX_FRAME_SIZE {
+		return CacheResponse{}, &RequestError{Err: errors.New(FRAME_TOO_LARGE_ERROR)}
+	}
+
+	result := make(chan tcpResult, 1)
+	this.mu.Lock()
+	if this.err != nil {
+		this.mu.Unlock()
+		return CacheResponse{}, &unsentError{err: this.err}
+	}
+	this.pending[frame.ID] = result
+	this.mu.Unlock()
+
+	if err := this.send(payload); err != nil {
+		this.close(err)
+		return CacheResponse{}, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-result:
+		return r.response, r.err
+	case <-ctx.Done():
+		this.forget(frame.ID)
+		return CacheResponse{}, ctx.Err()
+	case <-timer.C:
+		this.forget(frame.ID)
+		return CacheResponse{}, fmt.Errorf("request %d to %s timed out after %s", frame.ID, this.conn.RemoteAddr(), timeout)
+	}
+}
+
+func (this *tcpConn) forget(id uint64) {
+	this.mu.Lock()
+	delete(this.pending, id)
+	this.mu.Unlock()
+}
+
+func (this *tcpConn) load() int {
+	return int(atomic.LoadInt32(&this.inFlight))
+}
+
+func (this *tcpConn) lastUsed() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&this.used))
+}
+
+func (this *tcpConn) healthy() bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.err == nil
+}
+
+func (this *tcpConn) send(payload []byte) error {
+	this.writeMu.Lock()
+	defer this.writeMu.Unlock()
+	if err := writeFrame(this.writer, payload); err != nil {
+		return err
+	}
+	return this.writer.Flush()
+}
+
+func (this *tcpConn) readLoop() {
+	reader := bufio.NewReader(this.conn)
+	for {
+		payload, err := readFrame(reader)
+		if err != nil {
+			this.close(err)
+			return
+		}
+		var frame tcpFrame
+		if err := this.encoding.Unmarshal(payload, &frame); err != nil {
+			this.close(err)
+			return
+		}
+
+		this.mu.Lock()
+		result, ok := this.pending[frame.ID]
+		delete(this.pending, frame.ID)
+		this.mu.Unlock()
+		if !ok {
+			// The caller already gave up waiting.
+			continue
+		}
+		switch {
+		case frame.Error != "":
+			result <- tcpResult{err: &RequestError{Err: errors.New(frame.Error)}}
+		case frame.Response == nil:
+			result <- tcpResult{err: fmt.Errorf("response to request %d has no body", frame.ID)}
+		default:
+			result <- tcpResult{response: *frame.Response}
+		}
+	}
+}
+
+// close fails every pending request with err and retires the connection.
+func (this *tcpConn) close(err error) {
+	this.mu.Lock()
+	if this.err != nil {
+		this.mu.Unlock()
+		return
+	}
+	if err == io.EOF {
+		err = errors.New(CONNECTION_CLOSED_ERROR)
+	}
+	this.err = err
+	pending := this.pending
+	this.pending = nil
+	this.mu.Unlock()
+
+	this.conn.Close()
+	for _, result := range pending {
+		result <- tcpResult{err: err}
+	}
+	this.onClose(this)
+}
+
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) > TCP_MAX_FRAME_SIZE {
+		return errors.New(FRAME_TOO_LARGE_ERROR)
+	}
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > TCP_MAX_FRAME_SIZE {
+		return nil, errors.New(FRAME_TOO_LARGE_ERROR)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
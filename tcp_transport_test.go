@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tcpStandIn serves the framed TCP protocol in front of a fakeServer. Each
+// request is handled on its own goroutine after a delay that shrinks with
+// the request ID, so responses come back out of order.
+type tcpStandIn struct {
+	fake     *fakeServer
+	listener net.Listener
+	accepted int32
+	// fail, if set, is the error every request is answered with.
+	fail string
+}
+
+func newTCPStandIn(t *testing.T) *tcpStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	standIn := &tcpStandIn{
+		fake:     &fakeServer{objects: make(map[string]*fakeObject)},
+		listener: listener,
+	}
+	t.Cleanup(func() { listener.Close() })
+	go standIn.serve()
+	return standIn
+}
+
+func (this *tcpStandIn) serve() {
+	for {
+		conn, err := this.listener.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&this.accepted, 1)
+		go this.serveConn(conn)
+	}
+}
+
+func (this *tcpStandIn) serveConn(conn net.Conn) {
+	defer conn.Close()
+	encoding := JSONWireEncoding{}
+	reader := bufio.NewReader(conn)
+	var writeMu sync.Mutex
+
+	for {
+		payload, err := readFrame(reader)
+		if err != nil {
+			return
+		}
+		var frame tcpFrame
+		if err := encoding.Unmarshal(payload, &frame); err != nil {
+			return
+		}
+		go func(frame tcpFrame) {
+			time.Sleep(time.Duration(10-frame.ID%10) * time.Millisecond)
+			reply := tcpFrame{ID: frame.ID, Error: this.fail}
+			if this.fail == "" {
+				response := this.fake.apply(frame.Op, *frame.Request)
+				reply.Response = &response
+			}
+			data, _ := encoding.Marshal(reply)
+
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			writeFrame(conn, data)
+		}(frame)
+	}
+}
+
+func (this *tcpStandIn) port() string {
+	_, port, _ := net.SplitHostPort(this.listener.Addr().String())
+	return port
+}
+
+func TestTCPTransportPipelining(t *testing.T) {
+	standIn := newTCPStandIn(t)
+	cache, _ := newTestCache(t)
+	transport := NewTCPTransport(standIn.port())
+	defer transport.Close()
+	cache.SetTransport(transport)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key:%d", i)
+			_, err := cache.Put(key, i, NoExpiry)
+			AssertEqual(t, err, nil, "")
+			value, _, err := cache.GetInt64(key)
+			AssertEqual(t, err, nil, "")
+			AssertEqual(t, value, int64(i), "")
+		}(i)
+	}
+	wg.Wait()
+
+	AssertEqual(t, atomic.LoadInt32(&standIn.accepted), int32(1), "")
+	AssertEqual(t, len(standIn.fake.objects), 50, "")
+}
+
+func TestTCPTransportReconnects(t *testing.T) {
+	standIn := newTCPStandIn(t)
+	transport := NewTCPTransport(standIn.port())
+	defer transport.Close()
+	request := NewCacheRequest(CacheRequestParams{Key: "", Value: "", TTL: -1})
+
+	_, err := transport.RoundTrip(context.Background(), "127.0.0.1", "ping", request)
+	AssertEqual(t, err, nil, "")
+
+	// Break the connection underneath the transport.
+	for _, pool := range transport.pools {
+		pool.mu.Lock()
+		for _, conn := range pool.conns {
+			conn.conn.Close()
+		}
+		pool.mu.Unlock()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err = transport.RoundTrip(context.Background(), "127.0.0.1", "ping", request)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, atomic.LoadInt32(&standIn.accepted), int32(2), "")
+}
+
+func TestTCPTransportErrorFramesAreRequestErrors(t *testing.T) {
+	standIn := newTCPStandIn(t)
+	standIn.fail = "unknown op"
+	transport := NewTCPTransport(standIn.port())
+	defer transport.Close()
+
+	_, err := transport.RoundTrip(context.Background(), "127.0.0.1", "ping", NewCacheRequest(CacheRequestParams{TTL: -1}))
+	var requestErr *RequestError
+	AssertEqual(t, errors.As(err, &requestErr), true, "")
+	AssertEqual(t, requestErr.Node, "127.0.0.1", "")
+	AssertEqual(t, isNodeFailure(err), false, "")
+}
+
+func TestTCPTransportUnencodableRequestIsRequestError(t *testing.T) {
+	cache, _, standIn := newPooledTCPCache(t, PoolConfig{MaxConns: 1})
+	_, err := cache.Put("key", "value", NoExpiry)
+	AssertEqual(t, err, nil, "")
+
+	_, err = cache.Put("nan", math.NaN(), NoExpiry)
+	var requestErr *RequestError
+	AssertEqual(t, errors.As(err, &requestErr), true, "")
+	AssertEqual(t, len(cache.getDeadServers()), 0, "")
+
+	// The shared connection is still in use.
+	value, _, err := cache.GetString("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, value, "value", "")
+	AssertEqual(t, atomic.LoadInt32(&standIn.accepted), int32(1), "")
+}
+
+func TestTCPTransportTimeout(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	go func() {
+		// Accept but never answer.
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	transport := NewTCPTransport(port)
+	defer transport.Close()
+	transport.SetTimeout(20 * time.Millisecond)
+
+	_, err := transport.RoundTrip(context.Background(), "127.0.0.1", "ping", NewCacheRequest(CacheRequestParams{TTL: -1}))
+	AssertEqual(t, err != nil, true, "")
+}
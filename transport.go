@@ -0,0 +1,286 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const HTTP_MAX_RETRIES = 1
+
+// idempotentRequests are the request types that may be sent again after
+// the connection carrying them broke. The node may already have applied
+// the first attempt, so requests like incr, add and cas are never resent.
+var idempotentRequests = map[string]bool{
+	"ping":          true,
+	"get":           true,
+	"gets":          true,
+	"ttl":           true,
+	"put":           true,
+	"touch":         true,
+	"getAndTouch":   true,
+	"flush":         true,
+	"getSysMetrics": true,
+	"getAppMetrics": true,
+	"getNodeSize":   true,
+}
+
+// Transport carries requests to GhostDB nodes. The default speaks JSON
+// (or the configured wire encoding) over HTTP, and TCPTransport offers a
+// lower latency alternative.
+type Transport interface {
+	// RoundTrip sends request to node and waits for its response. An
+	// error means the node could not be reached or gave no usable reply,
+	// and causes the node to be marked dead, unless it is a RequestError,
+	// an AuthError or a StatusError below 500.
+	// ctx carries the request's Metadata and cancels it when done.
+	RoundTrip(ctx context.Context, node string, requestType string, request CacheRequest) (CacheResponse, error)
+}
+
+// SetTransport replaces the transport used to reach every node. The
+// default HTTP transport is closed when it is replaced; any other
+// transport is left for the caller to close. TCPTransport can't carry an
+// authenticator's credentials, so switching to it while one is set is an
+// error.
+func (this *Cache) SetTransport(transport Transport) error {
+	if _, ok := transport.(*TCPTransport); ok && this.authenticator != nil {
+		return errors.New(AUTHENTICATOR_TRANSPORT_ERROR)
+	}
+	if previous, ok := this.transport.(*httpTransport); ok && previous != transport {
+		previous.Close()
+	}
+	this.transport = transport
+	return nil
+}
+
+// StatusError is returned when a node answers over HTTP with anything
+// other than 200 OK. Only 5xx replies mark the node dead.
+type StatusError struct {
+	Node       string
+	StatusCode int
+}
+
+func (this *StatusError) Error() string {
+	return fmt.Sprintf("node %s responded with HTTP status %d", this.Node, this.StatusCode)
+}
+
+// httpTransport is the default Transport, sending a POST per request over
+// a connection pool kept for each node.
+type httpTransport struct {
+	cache *Cache
+
+	mu     sync.Mutex
+	pools  map[string]*httpPool
+	done   chan struct{}
+	closed bool
+}
+
+func newHTTPTransport(cache *Cache) *httpTransport {
+	transport := &httpTransport{
+		cache: cache,
+		pools: make(map[string]*httpPool),
+		done:  make(chan struct{}),
+	}
+	go transport.maintainPools()
+	return transport
+}
+
+func (this *httpTransport) RoundTrip(ctx context.Context, node string, requestType string, requestObj CacheRequest) (CacheResponse, error) {
+	addr, err := this.cache.nodeAddress(node)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	pool := this.pool(node, addr)
+	pool.begin()
+	defer pool.end()
+	return this.roundTrip(ctx, pool, node, requestType, requestObj, 0)
+}
+
+func (this *httpTransport) roundTrip(ctx context.Context, pool *httpPool, node string, requestType string, requestObj CacheRequest, attempt int) (CacheResponse, error) {
+	encoding := this.cache.wireEncodingFor(node)
+	requestBody, err := encoding.Marshal(requestObj)
+	if err != nil {
+		return CacheResponse{}, &RequestError{Node: node, Err: err}
+	}
+	requestBody, contentEncoding, err := this.cache.compressRequestBody(requestBody)
+	if err != nil {
+		return CacheResponse{}, &RequestError{Node: node, Err: err}
+	}
+
+	url := pool.addr.url(getRequestType(requestType))
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(requestBody))
+	if err != nil {
+		return CacheResponse{}, &RequestError{Node: node, Err: err}
+	}
+	for name, value := range MetadataFromContext(ctx) {
+		request.Header.Set(name, value)
+	}
+	request.Header.Set("Content-Type", encoding.ContentType())
+	request.Header.Set("Accept", encoding.ContentType())
+	if contentEncoding != "" {
+		request.Header.Set("Content-Encoding", contentEncoding)
+	}
+	if this.cache.bodyCompression {
+		request.Header.Set("Accept-Encoding", GZIP_CONTENT_ENCODING)
+	}
+	authenticator := this.cache.authenticator
+	if authenticator != nil {
+		if err := authenticator.Authenticate(request, requestBody); err != nil {
+			return CacheResponse{}, &RequestError{Node: node, Err: err}
+		}
+	}
+
+	resp, err := pool.client.Do(request)
+	if err != nil {
+		var dialErr *dialError
+		if !errors.As(err, &dialErr) && ctx.Err() == nil && idempotentRequests[requestType] && attempt < HTTP_MAX_RETRIES {
+			// The node was reachable but the connection broke under the
+			// request, so try a fresh one before the node is blamed.
+			// Requests that were never written are already retried by
+			// net/http.
+			pool.broken()
+			return this.roundTrip(ctx, pool, node, requestType, requestObj, attempt+1)
+		}
+		return CacheResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnsupportedMediaType && encoding.ContentType() != JSON_CONTENT_TYPE {
+		this.cache.fallBackToJSON(node)
+		return this.roundTrip(ctx, pool, node, requestType, requestObj, attempt)
+	}
+	if invalidator, ok := authenticator.(tokenInvalidator); ok && resp.StatusCode == http.StatusUnauthorized && attempt < HTTP_MAX_RETRIES {
+		invalidator.Invalidate()
+		return this.roundTrip(ctx, pool, node, requestType, requestObj, attempt+1)
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return CacheResponse{}, &AuthError{Node: node, StatusCode: resp.StatusCode}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return CacheResponse{}, &StatusError{Node: node, StatusCode: resp.StatusCode}
+	}
+
+	body, err := readResponseBody(resp)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+
+	var responseObj CacheResponse
+	err = wireEncodingForContentType(resp.Header.Get("Content-Type")).Unmarshal(body, &responseObj)
+	if err != nil {
+		return CacheResponse{}, err
+	}
+	return responseObj, nil
+}
+
+func (this *httpTransport) pool(node string, addr nodeAddress) *httpPool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	pool, ok := this.pools[node]
+	if !ok {
+		pool = newHTTPPool(this.cache.getPoolConfig(), addr, this.cache.dialContext)
+		this.pools[node] = pool
+	}
+	return pool
+}
+
+// resetPools starts new pools under the cache's current pool config and
+// dialer. The
+// old pools' connections close as their requests finish.
+func (this *httpTransport) resetPools() {
+	this.mu.Lock()
+	old := this.pools
+	this.pools = make(map[string]*httpPool)
+	this.mu.Unlock()
+
+	for _, pool := range old {
+		pool.transport.CloseIdleConnections()
+	}
+}
+
+// PoolStats returns connection pool stats keyed by node.
+func (this *httpTransport) PoolStats() map[string]PoolStats {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	stats := make(map[string]PoolStats, len(this.pools))
+	for node, pool := range this.pools {
+		stats[node] = pool.snapshot()
+	}
+	return stats
+}
+
+func (this *httpTransport) maintainPools() {
+	ticker := time.NewTicker(POOL_MAINTENANCE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-this.done:
+			return
+		case <-ticker.C:
+			this.mu.Lock()
+			pools := make([]*httpPool, 0, len(this.pools))
+			for _, pool := range this.pools {
+				pools = append(pools, pool)
+			}
+			this.mu.Unlock()
+			for _, pool := range pools {
+				pool.maintain()
+			}
+		}
+	}
+}
+
+// Close stops pool maintenance and closes idle connections. Requests
+// still in flight finish on their own.
+func (this *httpTransport) Close() error {
+	this.mu.Lock()
+	if this.closed {
+		this.mu.Unlock()
+		return nil
+	}
+	this.closed = true
+	close(this.done)
+	pools := this.pools
+	this.pools = make(map[string]*httpPool)
+	this.mu.Unlock()
+
+	for _, pool := range pools {
+		pool.transport.CloseIdleConnections()
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	// NoExpiry stores a value until it is deleted or evicted.
+	NoExpiry time.Duration = -1
+	// DefaultTTL defers to the TTL configured with SetDefaultTTL.
+	DefaultTTL time.Duration = 0
+)
+
+const (
+	// The server stores TTLs as whole seconds in a 32 bit integer.
+	MAX_TTL = time.Duration(math.MaxInt32) * time.Second
+)
+
+const (
+	NEGATIVE_TTL_ERROR = "TTL must be positive, NoExpiry or DefaultTTL"
+	TTL_OVERFLOW_ERROR = "TTL exceeds the maximum supported by the server"
+	INVALID_JITTER_ERROR = "TTL jitter must be between 0 and 1"
+)
+
+// SetDefaultTTL sets the TTL applied to writes made with DefaultTTL.
+func (this *Cache) SetDefaultTTL(ttl time.Duration) error {
+	if ttl == DefaultTTL {
+		ttl = NoExpiry
+	}
+	if err := validateTTL(ttl); err != nil {
+		return err
+	}
+	this.defaultTTL = ttl
+	return nil
+}
+
+// SetTTLJitter spreads expiry times by extending each TTL by a random
+// amount of up to fraction*ttl, so keys written together don't expire
+// together. A fraction of 0 disables jitter.
+func (this *Cache) SetTTLJitter(fraction float64) error {
+	if fraction < 0 || fraction > 1 {
+		return errors.New(INVALID_JITTER_ERROR)
+	}
+	this.ttlJitter = fraction
+	return nil
+}
+
+// resolveTTL converts a caller supplied TTL into the whole number of
+// seconds sent on the wire, where -1 means the key never expires.
+func (this *Cache) resolveTTL(ttl time.Duration) (int, error) {
+	if ttl == DefaultTTL {
+		ttl = this.defaultTTL
+	}
+	if err := validateTTL(ttl); err != nil {
+		return 0, err
+	}
+	if ttl == NoExpiry {
+		return -1, nil
+	}
+
+	if this.ttlJitter > 0 {
+		ttl += time.Duration(rand.Int63n(int64(float64(ttl)*this.ttlJitter) + 1))
+		if ttl > MAX_TTL {
+			ttl = MAX_TTL
+		}
+	}
+
+	// Round up so that sub-second TTLs don't become "expire immediately".
+	return int((ttl + time.Second - 1) / time.Second), nil
+}
+
+func validateTTL(ttl time.Duration) error {
+	if ttl < 0 && ttl != NoExpiry {
+		return errors.New(NEGATIVE_TTL_ERROR)
+	}
+	if ttl > MAX_TTL {
+		return errors.New(TTL_OVERFLOW_ERROR)
+	}
+	return nil
+}
+
+// ttlFromSeconds converts a TTL reported by the server back to a Duration.
+// Zero means the key expires within the second.
+func ttlFromSeconds(seconds int) time.Duration {
+	if seconds < 0 {
+		return NoExpiry
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// keepTTL converts a TTL reported by the server into one that rewrites the
+// key with the same remaining lifetime. A key about to expire keeps the
+// shortest TTL the server can store, since zero would mean DefaultTTL.
+func keepTTL(seconds int) time.Duration {
+	if seconds == 0 {
+		return time.Second
+	}
+	return ttlFromSeconds(seconds)
+}
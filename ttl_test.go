@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveTTL(t *testing.T) {
+	cache := &Cache{defaultTTL: NoExpiry}
+
+	seconds, err := cache.resolveTTL(NoExpiry)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, seconds, -1, "")
+
+	seconds, err = cache.resolveTTL(DefaultTTL)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, seconds, -1, "")
+
+	seconds, err = cache.resolveTTL(1500 * time.Millisecond)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, seconds, 2, "")
+
+	cache.SetDefaultTTL(time.Minute)
+	seconds, err = cache.resolveTTL(DefaultTTL)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, seconds, 60, "")
+}
+
+func TestResolveTTLRejectsInvalid(t *testing.T) {
+	cache := &Cache{defaultTTL: NoExpiry}
+
+	_, err := cache.resolveTTL(-5 * time.Second)
+	AssertEqual(t, err != nil, true, "")
+
+	_, err = cache.resolveTTL(MAX_TTL + time.Second)
+	AssertEqual(t, err != nil, true, "")
+
+	err = cache.SetDefaultTTL(-time.Hour)
+	AssertEqual(t, err != nil, true, "")
+	AssertEqual(t, cache.defaultTTL, NoExpiry, "")
+}
+
+func TestResolveTTLJitter(t *testing.T) {
+	cache := &Cache{defaultTTL: NoExpiry}
+	cache.SetTTLJitter(0.5)
+
+	for i := 0; i < 100; i++ {
+		seconds, err := cache.resolveTTL(100 * time.Second)
+		AssertEqual(t, err, nil, "")
+		if seconds < 100 || seconds > 150 {
+			t.Fatalf("jittered TTL %d outside [100, 150]", seconds)
+		}
+	}
+}
+
+func TestTTLFromSeconds(t *testing.T) {
+	AssertEqual(t, ttlFromSeconds(-1), NoExpiry, "")
+	AssertEqual(t, ttlFromSeconds(0), time.Duration(0), "")
+	AssertEqual(t, ttlFromSeconds(90), 90*time.Second, "")
+	AssertEqual(t, keepTTL(0), time.Second, "")
+	AssertEqual(t, keepTTL(-1), NoExpiry, "")
+}
+
+func TestTouchAndTTL(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.Put("key", "value", NoExpiry)
+
+	ttl, found, err := cache.TTL("key")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, found, true, "")
+	AssertEqual(t, ttl, NoExpiry, "")
+
+	_, err = cache.Touch("key", time.Minute)
+	AssertEqual(t, err, nil, "")
+	ttl, found, _ = cache.TTL("key")
+	AssertEqual(t, found, true, "")
+	AssertEqual(t, ttl > 0 && ttl <= time.Minute+time.Second, true, "")
+
+	// Touching with NoExpiry makes the key permanent again.
+	cache.Touch("key", NoExpiry)
+	ttl, _, _ = cache.TTL("key")
+	AssertEqual(t, ttl, NoExpiry, "")
+}
+
+func TestTTLMissingKey(t *testing.T) {
+	cache, _ := newTestCache(t)
+	ttl, found, err := cache.TTL("missing")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, found, false, "")
+	AssertEqual(t, ttl, time.Duration(0), "")
+
+	response, err := cache.Touch("missing", time.Minute)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, response.Message, CACHE_MISS, "")
+}
+
+func TestGetAndTouch(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.Put("key", "value", time.Hour)
+
+	response, err := cache.GetAndTouch("key", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, response.Gobj.Value, "value", "")
+	ttl, _, _ := cache.TTL("key")
+	AssertEqual(t, ttl, NoExpiry, "")
+
+	response, err = cache.GetAndTouch("missing", time.Minute)
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, response.Message, CACHE_MISS, "")
+}
+
+func TestTouchRejectsInvalidTTL(t *testing.T) {
+	cache, _ := newTestCache(t)
+	_, err := cache.Touch("key", -5*time.Second)
+	AssertEqual(t, err != nil, true, "")
+	_, err = cache.GetAndTouch("key", MAX_TTL+time.Second)
+	AssertEqual(t, err != nil, true, "")
+}
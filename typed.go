@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The typed getters return found == false for keys that are not cached or
+// are known absent, and a *TypeError if the stored value can't be
+// converted. Values written with a codec are decoded by that codec
+// directly; anything else is converted from the form encoding/json gives
+// it, so for example whole numbers stored as JSON numbers are accepted by
+// GetInt64.
+
+func (this *Cache) GetString(key string) (string, bool, error) {
+	var value string
+	found, err := this.getAs(key, "string", &value, func(v interface{}) bool {
+		s, ok := v.(string)
+		value = s
+		return ok
+	})
+	return value, found, err
+}
+
+func (this *Cache) GetInt64(key string) (int64, bool, error) {
+	var value int64
+	found, err := this.getAs(key, "int64", &value, func(v interface{}) bool {
+		var ok bool
+		value, ok = toInt64(v)
+		return ok
+	})
+	return value, found, err
+}
+
+func (this *Cache) GetFloat64(key string) (float64, bool, error) {
+	var value float64
+	found, err := this.getAs(key, "float64", &value, func(v interface{}) bool {
+		var ok bool
+		value, ok = toFloat64(v)
+		return ok
+	})
+	return value, found, err
+}
+
+func (this *Cache) GetBool(key string) (bool, bool, error) {
+	var value bool
+	found, err := this.getAs(key, "bool", &value, func(v interface{}) bool {
+		switch b := v.(type) {
+		case bool:
+			value = b
+			return true
+		case string:
+			parsed, err := strconv.ParseBool(b)
+			value = parsed
+			return err == nil
+		}
+		return false
+	})
+	return value, found, err
+}
+
+// GetBytes returns a []byte value. Strings are base64 decoded, as that is
+// how encoding/json sends []byte values written without a codec.
+func (this *Cache) GetBytes(key string) ([]byte, bool, error) {
+	var value []byte
+	found, err := this.getAs(key, "[]byte", &value, func(v interface{}) bool {
+		switch b := v.(type) {
+		case []byte:
+			value = b
+			return true
+		case string:
+			decoded, err := base64.StdEncoding.DecodeString(b)
+			value = decoded
+			return err == nil
+		}
+		return false
+	})
+	return value, found, err
+}
+
+// GetTime returns a time.Time value. Strings are parsed as RFC 3339, as
+// written by encoding/json, and numbers as Unix seconds.
+func (this *Cache) GetTime(key string) (time.Time, bool, error) {
+	var value time.Time
+	found, err := this.getAs(key, "time.Time", &value, func(v interface{}) bool {
+		switch t := v.(type) {
+		case time.Time:
+			value = t
+			return true
+		case string:
+			parsed, err := time.Parse(time.RFC3339Nano, t)
+			value = parsed
+			return err == nil
+		}
+		seconds, ok := toFloat64(v)
+		if !ok {
+			return false
+		}
+		whole, frac := math.Modf(seconds)
+		value = time.Unix(int64(whole), int64(frac*1e9))
+		return true
+	})
+	return value, found, err
+}
+
+// GetJSON decodes the value at key into dst, which must be a pointer, as
+// encoding/json would. It is equivalent to GetInto.
+func (this *Cache) GetJSON(key string, dst interface{}) (bool, error) {
+	return this.GetInto(key, dst)
+}
+
+// getAs reads key into dst, using the value's codec when it has one and
+// otherwise handing the JSON decoded value to convert.
+func (this *Cache) getAs(key string, want string, dst interface{}, convert func(interface{}) bool) (bool, error) {
+	response, env, err := this.get(context.Background(), key, true)
+	if err != nil {
+		return false, err
+	}
+	if IsNotCached(response) || IsKnownAbsent(response) {
+		return false, nil
+	}
+	if env != nil && env.Codec != "" && decodeInto(nil, env, dst) == nil {
+		return true, nil
+	}
+	if !convert(response.Gobj.Value) {
+		return true, &TypeError{Key: key, Want: want, Value: response.Gobj.Value}
+	}
+	return true, nil
+}
+
+// toInt64 converts a decoded value to an int64. Numbers arrive as float64
+// from JSON, or as sized integers from other codecs, but servers may also
+// hand back counters as strings.
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v != math.Trunc(v) || v >= math.MaxInt64 || v < math.MinInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case float32:
+		return toInt64(float64(v))
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return toInt64(uint64(v))
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	if n, ok := toInt64(value); ok {
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestToInt64(t *testing.T) {
+	var value int64
+	var ok bool
+
+	value, ok = toInt64(float64(42))
+	AssertEqual(t, ok, true, "")
+	AssertEqual(t, value, int64(42), "")
+
+	value, ok = toInt64("-7")
+	AssertEqual(t, ok, true, "")
+	AssertEqual(t, value, int64(-7), "")
+
+	value, ok = toInt64(json.Number("9007199254740993"))
+	AssertEqual(t, ok, true, "")
+	AssertEqual(t, value, int64(9007199254740993), "")
+
+	value, ok = toInt64(uint8(200))
+	AssertEqual(t, ok, true, "")
+	AssertEqual(t, value, int64(200), "")
+
+	_, ok = toInt64(float64(1.5))
+	AssertEqual(t, ok, false, "")
+
+	_, ok = toInt64("Dublin")
+	AssertEqual(t, ok, false, "")
+}
+
+func TestTypedGetters(t *testing.T) {
+	cache, _ := newTestCache(t)
+	now := time.Date(2020, 4, 1, 12, 30, 0, 500, time.UTC)
+
+	cache.Put("name", "Dublin", NoExpiry)
+	cache.Put("population", 1173179, NoExpiry)
+	cache.Put("area", 117.8, NoExpiry)
+	cache.Put("capital", true, NoExpiry)
+	cache.Put("flag", []byte{0, 155, 72}, NoExpiry)
+	cache.Put("founded", now, NoExpiry)
+
+	name, found, err := cache.GetString("name")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, found, true, "")
+	AssertEqual(t, name, "Dublin", "")
+
+	population, _, err := cache.GetInt64("population")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, population, int64(1173179), "")
+
+	area, _, err := cache.GetFloat64("area")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, area, 117.8, "")
+
+	capital, _, err := cache.GetBool("capital")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, capital, true, "")
+
+	flag, _, err := cache.GetBytes("flag")
+	AssertEqual(t, err, nil, "")
+	AssertDeepEqual(t, flag, []byte{0, 155, 72}, "")
+
+	founded, _, err := cache.GetTime("founded")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, founded.Equal(now), true, "")
+
+	_, found, err = cache.GetString("missing")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, found, false, "")
+
+	_, found, err = cache.GetInt64("name")
+	_, isTypeError := err.(*TypeError)
+	AssertEqual(t, found, true, "")
+	AssertEqual(t, isTypeError, true, "")
+}
+
+func TestTypedGettersWithCodec(t *testing.T) {
+	cache, _ := newTestCache(t)
+	cache.SetCodec(GobCodec{})
+
+	cache.Put("population", int64(1173179), NoExpiry)
+	population, _, err := cache.GetInt64("population")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, population, int64(1173179), "")
+}
@@ -49,6 +49,8 @@ type CacheRequestParams struct {
 	Key   string
 	Value interface{}
 	TTL   int
+	Version uint64
+	Delta   int64
 }
 
 type Metric struct {
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	JSON_CONTENT_TYPE = "application/json"
+	MSGPACK_CONTENT_TYPE = "application/msgpack"
+)
+
+// WireEncoding serializes requests to, and responses from, GhostDB nodes.
+type WireEncoding interface {
+	ContentType() string
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, value interface{}) error
+}
+
+// SetWireEncoding sends requests using encoding instead of JSON. Nodes that
+// reject it with 415 Unsupported Media Type are remembered and spoken to
+// in JSON from then on. Passing nil restores JSON for every node.
+func (this *Cache) SetWireEncoding(encoding WireEncoding) {
+	this.wireMu.Lock()
+	defer this.wireMu.Unlock()
+	this.wireEncoding = encoding
+	this.jsonOnlyNodes = make(map[string]bool)
+}
+
+// wireEncodingFor returns the encoding negotiated with node.
+func (this *Cache) wireEncodingFor(node string) WireEncoding {
+	this.wireMu.Lock()
+	defer this.wireMu.Unlock()
+	if this.wireEncoding == nil || this.jsonOnlyNodes[node] {
+		return JSONWireEncoding{}
+	}
+	return this.wireEncoding
+}
+
+func (this *Cache) fallBackToJSON(node string) {
+	this.wireMu.Lock()
+	defer this.wireMu.Unlock()
+	if this.jsonOnlyNodes != nil {
+		this.jsonOnlyNodes[node] = true
+	}
+}
+
+// wireEncodingForContentType picks the decoder for a response, which may
+// differ from the request's encoding if the node chose to answer in JSON.
+func wireEncodingForContentType(contentType string) WireEncoding {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == MSGPACK_CONTENT_TYPE {
+		return MsgpackWireEncoding{}
+	}
+	return JSONWireEncoding{}
+}
+
+type JSONWireEncoding struct{}
+
+func (JSONWireEncoding) ContentType() string {
+	return JSON_CONTENT_TYPE
+}
+
+func (JSONWireEncoding) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONWireEncoding) Unmarshal(data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+// MsgpackWireEncoding uses the same field names as the JSON encoding, so
+// nodes can map either onto the same structures.
+type MsgpackWireEncoding struct{}
+
+func (MsgpackWireEncoding) ContentType() string {
+	return MSGPACK_CONTENT_TYPE
+}
+
+func (MsgpackWireEncoding) Marshal(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (MsgpackWireEncoding) Unmarshal(data []byte, value interface{}) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	decoder.SetCustomStructTag("json")
+	return decoder.Decode(value)
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2020, Jake Grogan
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *
+ *  * Redistributions of source code must retain the above copyright notice, this
+ *    list of conditions and the following disclaimer.
+ *
+ *  * Redistributions in binary form must reproduce the above copyright notice,
+ *    this list of conditions and the following disclaimer in the documentation
+ *    and/or other materials provided with the distribution.
+ *
+ *  * Neither the name of the copyright holder nor the names of its
+ *    contributors may be used to endorse or promote products derived from
+ *    this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+ * AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+ * IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+ * FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+ * DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+ * CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+ * OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestMsgpackWireEncoding(t *testing.T) {
+	cache, fake := newTestCache(t)
+	fake.acceptMsgpack = true
+	cache.SetWireEncoding(MsgpackWireEncoding{})
+
+	cache.Put("Ireland", "Dublin", NoExpiry)
+	response, err := cache.Get("Ireland")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, response.Gobj.Value, "Dublin", "")
+	node := cache.ring.GetPoint("Ireland").value.ip
+	AssertEqual(t, cache.wireEncodingFor(node), MsgpackWireEncoding{}, "")
+
+	// Envelopes survive the trip too.
+	cache.SetCompression(GzipCompressor{}, 0)
+	cache.Put("Ireland", "Dublin", NoExpiry)
+	response, err = cache.Get("Ireland")
+	AssertEqual(t, err, nil, "")
+	AssertEqual(t, response.Gobj.Value, "Dublin", "")
+}
+
+func TestWireEncodingFallsBackToJSON(t *testing.T) {
+	cache, fake := newTestCache(t)
+	cache.SetWireEncoding(MsgpackWireEncoding{})
+
+	_, err := cache.Put("Ireland", "Dublin", NoExpiry)
+	AssertEqual(t, err, nil, "")
+	response, _ := cache.Get("Ireland")
+	AssertEqual(t, response.Gobj.Value, "Dublin", "")
+
+	node := cache.ring.GetPoint("Ireland").value.ip
+	AssertEqual(t, cache.wireEncodingFor(node), JSONWireEncoding{}, "")
+	AssertEqual(t, len(fake.objects), 1, "")
+}
+
+func benchmarkWireEncoding(b *testing.B, encoding WireEncoding) {
+	request := NewCacheRequest(CacheRequestParams{Key: "session:8f14e45f", Value: "active", TTL: 300})
+	response := CacheResponse{Gobj: request.Gobj, Status: 1, Message: "OK"}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		data, _ := encoding.Marshal(request)
+		var decodedRequest CacheRequest
+		encoding.Unmarshal(data, &decodedRequest)
+
+		data, _ = encoding.Marshal(response)
+		var decodedResponse CacheResponse
+		encoding.Unmarshal(data, &decodedResponse)
+	}
+}
+
+func BenchmarkJSONWireEncoding(b *testing.B) {
+	benchmarkWireEncoding(b, JSONWireEncoding{})
+}
+
+func BenchmarkMsgpackWireEncoding(b *testing.B) {
+	benchmarkWireEncoding(b, MsgpackWireEncoding{})
+}
+
+func benchmarkRoundTrip(b *testing.B, encoding WireEncoding) {
+	cache, _ := newTestCacheFor(b, &fakeServer{objects: make(map[string]*fakeObject), acceptMsgpack: true})
+	cache.SetWireEncoding(encoding)
+	cache.Put("session:8f14e45f", "active", NoExpiry)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Get("session:8f14e45f")
+	}
+}
+
+func BenchmarkJSONRoundTrip(b *testing.B) {
+	benchmarkRoundTrip(b, JSONWireEncoding{})
+}
+
+func BenchmarkMsgpackRoundTrip(b *testing.B) {
+	benchmarkRoundTrip(b, MsgpackWireEncoding{})
+}